@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/metrics"
+)
+
+const usage = `Usage: counter-cli <command> [flags]
+
+Commands:
+  show      Print the value, last update time and version from the counter file
+  selftest  Save and reload a test counter file to check that persistence works
+
+Run "counter-cli <command> -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command in args and returns the process exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "show":
+		return runShow(args[1:], stdout, stderr)
+	case "selftest":
+		return runSelfTest(args[1:], stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+// runShow prints the contents of the counter file. It reads the file
+// directly under a shared lock, so it works while the server is running and
+// validates the CRC the same way the server does on startup.
+func runShow(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "path to the config file (default $CONFIG_FILE, else config.yaml in . or /etc/counter/)")
+	file := fs.String("file", "", "counter file to read (default from configuration)")
+	asJSON := fs.Bool("json", false, "print the counter data as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Use the server's configuration so the file and lock timeout match
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *file != "" {
+		cfg.Filename = *file
+	}
+
+	data, err := counter.ReadCounterFile(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(data); err != nil {
+			fmt.Fprintf(stderr, "failed to encode counter data: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stdout, "visits:       %d\n", data.Visits)
+	fmt.Fprintf(stdout, "peak:         %d\n", max(data.Peak, data.Visits))
+	fmt.Fprintf(stdout, "last_updated: %s\n", data.Timestamp.Format(time.RFC3339))
+	fmt.Fprintf(stdout, "version:      %s\n", data.Version)
+	return 0
+}
+
+// runSelfTest saves and reloads a temporary counter file next to the
+// configured one, printing each step with its timing. It exits with status 1
+// if any step fails, so it can gate deployments and CI runs.
+func runSelfTest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "path to the config file (default $CONFIG_FILE, else config.yaml in . or /etc/counter/)")
+	dir := fs.String("dir", "", "directory to test (default the directory of the configured counter file)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+	if *dir == "" {
+		*dir = filepath.Dir(cfg.Filename)
+	}
+
+	logger := zerolog.Nop()
+	start := time.Now()
+	steps, err := counter.SelfTest(context.Background(), cfg, *dir, &logger, metrics.NewMetrics(cfg))
+	for _, step := range steps {
+		status := "ok"
+		if step.Err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(stdout, "%-8s %-4s %s\n", step.Name, status, step.Duration.Round(time.Microsecond))
+	}
+
+	if err != nil {
+		fmt.Fprintf(stdout, "FAIL in %s: %v\n", time.Since(start).Round(time.Microsecond), err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "PASS in %s\n", time.Since(start).Round(time.Microsecond))
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+// writeCounterFile saves visits to a counter file in a temporary directory
+// the way the server does, and returns the path of a config file naming it
+func writeCounterFile(t *testing.T, visits int64) (configPath, counterPath string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	counterPath = filepath.Join(dir, "counter.json")
+	configPath = filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte("filename: "+counterPath+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	c := counter.NewCounter(0)
+	c.Set(visits)
+	logger := zerolog.Nop()
+	if err := counter.SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, metrics.NewMetrics(&config.Config{})); err != nil {
+		t.Fatalf("Failed to save counter: %v", err)
+	}
+	return configPath, counterPath
+}
+
+func TestShow(t *testing.T) {
+	configPath, _ := writeCounterFile(t, 1234)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"show", "-config", configPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("show exited %d: %s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"visits:       1234\n", "last_updated: ", "version:      " + config.Version} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output %q does not contain %q", out, want)
+		}
+	}
+
+	stdout.Reset()
+	if code := run([]string{"show", "-config", configPath, "-json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("show -json exited %d: %s", code, stderr.String())
+	}
+	var data counter.CounterData
+	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
+		t.Fatalf("show -json printed %q: %v", stdout.String(), err)
+	}
+	if data.Visits != 1234 {
+		t.Errorf("show -json visits = %d, want 1234", data.Visits)
+	}
+}
+
+func TestShowRejectsCorruptFile(t *testing.T) {
+	configPath, counterPath := writeCounterFile(t, 1234)
+
+	// Change the value without updating the CRC
+	content, err := os.ReadFile(counterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(counterPath, bytes.Replace(content, []byte("1234"), []byte("9999"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"show", "-config", configPath}, &stdout, &stderr); code != 1 {
+		t.Errorf("show on a corrupt file exited %d, want 1", code)
+	}
+	if stdout.Len() != 0 || stderr.Len() == 0 {
+		t.Errorf("stdout %q, stderr %q; want only an error", stdout.String(), stderr.String())
+	}
+}
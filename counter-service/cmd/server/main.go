@@ -1,34 +1,75 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/api"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/counter"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/internal/tracing"
 	"github.com/yourusername/counter-service/pkg/logging"
 )
 
 func main() {
+	configFile := flag.String("config", "", "path to the config file (default $CONFIG_FILE, else config.yaml in . or /etc/counter/)")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
 	// Setup logging
-	logger := logging.NewLogger(cfg.LogLevel, cfg.Environment)
+	if cfg.UTCTimestamps {
+		logging.UseUTC()
+	}
+	var logger *zerolog.Logger
+	var closeLogs io.Closer = io.NopCloser(nil)
+	if cfg.AsyncLogging {
+		logger, closeLogs = logging.NewAsyncLogger(cfg.LogLevel, cfg.Environment, cfg.AsyncLogBufferSize)
+	} else {
+		logger = logging.NewLogger(cfg.LogLevel, cfg.Environment)
+	}
+	if cfg.LogFile != "" {
+		fileOpts := logging.FileLogOptions{
+			MaxSizeMB:  cfg.LogMaxSizeMB,
+			MaxBackups: cfg.LogMaxBackups,
+			MaxAgeDays: cfg.LogMaxAgeDays,
+		}
+		if cfg.AsyncLogging {
+			fileOpts.AsyncBufferSize = cfg.AsyncLogBufferSize
+		}
+
+		// File logging replaces the logger's output, so flush the old one first
+		closeLogs.Close()
+		closeLogs, err = logging.SetupFileLogging(logger, cfg.LogFile, fileOpts)
+		if err != nil {
+			log.Fatalf("Failed to set up file logging: %v", err)
+		}
+	}
 	logger.Info().
 		Str("version", config.Version).
 		Str("environment", cfg.Environment).
 		Msg("Counter service starting")
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
 	// Initialize metrics
-	metrics := metrics.NewMetrics()
+	metrics := metrics.NewMetrics(cfg)
 
 	// Initialize counter service
 	counterService, err := counter.NewService(cfg, logger, metrics)
@@ -39,10 +80,24 @@ func main() {
 	// Initialize API server
 	server := api.NewServer(cfg, logger, counterService, metrics)
 
+	// Push metrics for runs too short to be scraped
+	stopPush := make(chan struct{})
+	pushDone := make(chan struct{})
+	if cfg.PushgatewayURL != "" {
+		go runMetricsPush(metrics.NewPusher(cfg), cfg.PushgatewayInterval, cfg.ShutdownTimeout, logger, stopPush, pushDone)
+	} else {
+		close(pushDone)
+	}
+
 	// Handle graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	// Save on demand, as a checkpoint before maintenance
+	flush := make(chan os.Signal, 1)
+	signal.Notify(flush, syscall.SIGUSR1)
+	go persistOnSignal(counterService, cfg.ShutdownTimeout, logger, flush)
+
 	// Start server in a goroutine
 	go func() {
 		if err := server.Start(); err != nil {
@@ -55,11 +110,90 @@ func main() {
 	// Wait for interrupt signal
 	<-stop
 	logger.Info().Msg("Shutdown signal received")
+	signal.Stop(flush)
+
+	// Stop the server, then make the final save
+	shutdown(server, counterService, logger)
+
+	// Push the final metrics, after the last save
+	close(stopPush)
+	<-pushDone
+
+	// Flush any buffered spans
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error().Err(err).Msg("Error shutting down tracing")
+	}
+
+	logger.Info().Msg("Server shutdown complete")
 
-	// Shutdown server
+	// Flush buffered log messages
+	closeLogs.Close()
+}
+
+// shutdown stops the server taking requests, then shuts down the counter
+// service, which stops its background saves, saves the counter a last time
+// and removes the owner lock file
+func shutdown(server *api.Server, counterService *counter.Service, logger *zerolog.Logger) {
 	if err := server.Shutdown(); err != nil {
 		logger.Error().Err(err).Msg("Error during server shutdown")
 	}
+	if err := counterService.Shutdown(); err != nil {
+		logger.Error().Err(err).Msg("Error shutting down counter service")
+	}
+}
 
-	logger.Info().Msg("Server shutdown complete")
+// runMetricsPush pushes metrics every interval, if positive, and once more
+// when stop is closed, then closes done
+func runMetricsPush(pusher *metrics.Pusher, interval, timeout time.Duration, logger *zerolog.Logger, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	push := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := pusher.Push(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to push metrics to Pushgateway")
+		}
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			push()
+		case <-stop:
+			push()
+			return
+		}
+	}
+}
+
+// persistOnSignal saves the counter each time a signal arrives, logging the
+// outcome. A counter with nothing unsaved is not written.
+func persistOnSignal(counterService *counter.Service, timeout time.Duration, logger *zerolog.Logger, signals <-chan os.Signal) {
+	for sig := range signals {
+		dirty := counterService.PersistStatus().Dirty
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := counterService.Persist(ctx)
+		cancel()
+
+		if err != nil {
+			logger.Error().Err(err).Str("signal", sig.String()).Msg("Failed to save counter on signal")
+			continue
+		}
+		if !dirty {
+			logger.Info().Str("signal", sig.String()).Msg("Counter already saved, nothing to flush")
+			continue
+		}
+		logger.Info().
+			Str("signal", sig.String()).
+			Time("lastPersist", counterService.PersistStatus().LastPersistTime).
+			Msg("Counter saved on signal")
+	}
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/api"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/metrics"
+)
+
+// pushRequest is a request received by the fake Pushgateway
+type pushRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        []byte
+}
+
+func TestRunMetricsPushOnShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []pushRequest
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushes = append(pushes, pushRequest{r.Method, r.URL.Path, r.Header.Get("Content-Type"), body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	cfg := &config.Config{
+		PushgatewayURL: gateway.URL,
+		PushgatewayJob: "counter-batch",
+		Filename:       "/data/visits.json",
+	}
+	m := metrics.NewMetrics(&config.Config{})
+	m.CounterValue.Set(42)
+	logger := zerolog.Nop()
+
+	// No interval, so the only push is the one on shutdown
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go runMetricsPush(m.NewPusher(cfg), 0, time.Second, &logger, stop, done)
+
+	mu.Lock()
+	if len(pushes) != 0 {
+		t.Fatalf("%d pushes before shutdown, want 0", len(pushes))
+	}
+	mu.Unlock()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runMetricsPush did not finish after stop")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushes) != 1 {
+		t.Fatalf("%d pushes on shutdown, want 1", len(pushes))
+	}
+	push := pushes[0]
+
+	// The grouping labels follow the job in no particular order
+	host, _ := os.Hostname()
+	if push.method != http.MethodPut || !strings.HasPrefix(push.path, "/metrics/job/counter-batch/") {
+		t.Errorf("Push = %s %s, want PUT for job counter-batch", push.method, push.path)
+	}
+	for _, label := range []string{"/instance/" + host, "/counter/visits.json"} {
+		if !strings.Contains(push.path+"/", label+"/") {
+			t.Errorf("Push path %s is missing grouping label %s", push.path, label)
+		}
+	}
+	if !strings.Contains(push.contentType, "protobuf") || len(push.body) == 0 {
+		t.Errorf("Push payload is %d bytes of %q, want the encoded metrics", len(push.body), push.contentType)
+	}
+	if !strings.Contains(string(push.body), "counter_current_value") {
+		t.Error("Push payload does not include the counter value")
+	}
+}
+
+func TestPersistOnSignal(t *testing.T) {
+	cfg := &config.Config{
+		Filename:            filepath.Join(t.TempDir(), "counter.json"),
+		FilePermissions:     0644,
+		SaveRetryAttempts:   1,
+		LockTimeout:         time.Second,
+		SerializationFormat: "json",
+		IntegrityAlgorithm:  "crc32",
+		PersistInterval:     time.Hour, // only the signal saves
+		IncrementRateWindow: time.Minute,
+	}
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	service, err := counter.NewService(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+
+	flush := make(chan os.Signal, 1)
+	signal.Notify(flush, syscall.SIGUSR1)
+	defer signal.Stop(flush)
+	go persistOnSignal(service, time.Second, &logger, flush)
+
+	service.Increment(context.Background())
+	if _, err := os.Stat(cfg.Filename); !os.IsNotExist(err) {
+		t.Fatalf("Counter file exists before the signal: %v", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := counter.ReadCounterFile(cfg); err == nil && data.Visits == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Counter was not saved after SIGUSR1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if service.PersistStatus().Dirty {
+		t.Error("Counter is dirty after the signal save")
+	}
+}
+
+func TestShutdownRemovesOwnerLock(t *testing.T) {
+	cfg := &config.Config{
+		Filename:            filepath.Join(t.TempDir(), "counter.json"),
+		FilePermissions:     0644,
+		SaveRetryAttempts:   1,
+		LockTimeout:         time.Second,
+		SerializationFormat: "json",
+		IntegrityAlgorithm:  "crc32",
+		PersistInterval:     time.Hour,
+		IncrementRateWindow: time.Minute,
+		ShutdownTimeout:     time.Second,
+		OwnerLock:           true,
+	}
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	service, err := counter.NewService(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	if _, err := os.Stat(cfg.Filename + ".lock"); err != nil {
+		t.Fatalf("Owner lock file missing while running: %v", err)
+	}
+	service.Increment(context.Background())
+
+	shutdown(api.NewServer(cfg, &logger, service, m), service, &logger)
+
+	if _, err := os.Stat(cfg.Filename + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Owner lock file still present after shutdown: %v", err)
+	}
+	if data, err := counter.ReadCounterFile(cfg); err != nil || data.Visits != 1 {
+		t.Errorf("Saved visits = %d, %v after shutdown; want 1", data.Visits, err)
+	}
+
+	// The next instance starts on the same file
+	next, err := counter.NewService(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Restart after a clean shutdown failed: %v", err)
+	}
+	next.Shutdown()
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/pkg/logging"
+)
+
+// Body logging limits. Bodies are captured up to maxCapturedBodyBytes so
+// they can be parsed for redaction, then cut to maxLoggedBodyBytes.
+const (
+	maxCapturedBodyBytes = 64 << 10
+	maxLoggedBodyBytes   = 2 << 10
+)
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest,
+// remembering that it did
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write always reports success, so it never fails the tee it sits behind
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room < len(p) {
+		c.truncated = true
+		if room > 0 {
+			c.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// bodyLogWriter copies what the handler writes into a cappedBuffer
+type bodyLogWriter struct {
+	http.ResponseWriter
+	body *cappedBuffer
+}
+
+// Write captures the response body on its way out
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *bodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyLogMiddleware logs the request and response bodies of write requests
+// at debug level, redacted by rd and truncated. The request body is copied
+// as the handler reads it, so the handler still gets all of it. Nothing is
+// captured unless logger is at debug level.
+func bodyLogMiddleware(logger *zerolog.Logger, rd *redactor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !writeMethod(r.Method) || !debugEnabled(logger) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqBody := &cappedBuffer{max: maxCapturedBodyBytes}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+
+			respBody := &cappedBuffer{max: maxCapturedBodyBytes}
+			next.ServeHTTP(&bodyLogWriter{ResponseWriter: w, body: respBody}, r)
+
+			logging.FromContext(r.Context(), logger).Debug().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("requestBody", loggedBody(reqBody, rd)).
+				Str("responseBody", loggedBody(respBody, rd)).
+				Msg("Request bodies")
+		})
+	}
+}
+
+// writeMethod reports whether method may change server state
+func writeMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// debugEnabled reports whether logger writes debug messages
+func debugEnabled(logger *zerolog.Logger) bool {
+	return logger.GetLevel() <= zerolog.DebugLevel && zerolog.GlobalLevel() <= zerolog.DebugLevel
+}
+
+// loggedBody returns a captured body redacted and cut to maxLoggedBodyBytes
+func loggedBody(c *cappedBuffer, rd *redactor) string {
+	if c.buf.Len() == 0 {
+		return ""
+	}
+
+	// A body cut off during capture cannot be parsed for redaction
+	if c.truncated {
+		return redactedValue
+	}
+
+	body := rd.body(c.buf.Bytes())
+	if len(body) > maxLoggedBodyBytes {
+		body = body[:maxLoggedBodyBytes] + "...(truncated)"
+	}
+	return body
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestBodyLogMiddleware(t *testing.T) {
+	const reqBody = `{"expected":41,"token":"s3cret"}`
+
+	for _, tc := range []struct {
+		name   string
+		level  zerolog.Level
+		method string
+		logged bool
+	}{
+		{"debug write", zerolog.DebugLevel, http.MethodPost, true},
+		{"info write", zerolog.InfoLevel, http.MethodPost, false},
+		{"debug read", zerolog.DebugLevel, http.MethodGet, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := zerolog.New(&out).Level(tc.level)
+			var seen []byte
+			handler := bodyLogMiddleware(&logger, newRedactor(nil, []string{"token"}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = io.ReadAll(r.Body)
+				w.Write([]byte(`{"visits":42}`))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, "/api/counter/compare-and-increment", strings.NewReader(reqBody)))
+
+			// The handler gets the whole body whether or not it is logged
+			if string(seen) != reqBody {
+				t.Errorf("Handler read %q, want %q", seen, reqBody)
+			}
+			if !tc.logged {
+				if out.Len() != 0 {
+					t.Errorf("Logged %s, want nothing", out.String())
+				}
+				return
+			}
+
+			var entry struct {
+				RequestBody  string `json:"requestBody"`
+				ResponseBody string `json:"responseBody"`
+			}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("Log is not one JSON entry: %v: %s", err, out.String())
+			}
+			if entry.RequestBody != `{"expected":41,"token":"REDACTED"}` {
+				t.Errorf("Logged request body %q, want it with the token masked", entry.RequestBody)
+			}
+			if entry.ResponseBody != `{"visits":42}` {
+				t.Errorf("Logged response body %q, want %q", entry.ResponseBody, `{"visits":42}`)
+			}
+		})
+	}
+}
+
+func TestLoggedBodyTruncation(t *testing.T) {
+	rd := newRedactor(nil, nil)
+
+	long := &cappedBuffer{max: maxCapturedBodyBytes}
+	long.Write([]byte(`{"padding":"` + strings.Repeat("x", maxLoggedBodyBytes) + `"}`))
+	if got := loggedBody(long, rd); len(got) != maxLoggedBodyBytes+len("...(truncated)") || !strings.HasSuffix(got, "...(truncated)") {
+		t.Errorf("Logged %d bytes of a long body, want it cut to %d", len(got), maxLoggedBodyBytes)
+	}
+
+	// A body cut off during capture cannot be redacted, so it is hidden
+	huge := &cappedBuffer{max: maxCapturedBodyBytes}
+	huge.Write(bytes.Repeat([]byte("x"), maxCapturedBodyBytes+1))
+	if got := loggedBody(huge, rd); got != redactedValue {
+		t.Errorf("Logged %.40q for a body over the capture limit, want it hidden", got)
+	}
+}
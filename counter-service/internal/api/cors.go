@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rs/cors"
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// allowsAnyOrigin reports whether the origin list contains the "*" wildcard
+func allowsAnyOrigin(origins []string) bool {
+	for _, origin := range origins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware handles CORS for the configured origins. Requests from a
+// listed origin get that origin echoed back in Access-Control-Allow-Origin,
+// which browsers require for credentialed requests. The "*" wildcard is only
+// honoured with credentials off, since browsers reject that combination.
+func corsMiddleware(cfg *config.Config, logger *zerolog.Logger) func(http.Handler) http.Handler {
+	allowCredentials := cfg.CORSAllowCredentials
+	if allowCredentials && allowsAnyOrigin(cfg.AllowedOrigins) {
+		logger.Warn().
+			Strs("allowedOrigins", cfg.AllowedOrigins).
+			Msg("CORS credentials cannot be combined with a wildcard origin, disabling credentials; list explicit origins to allow them")
+		allowCredentials = false
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   []string{requestIDHeader, idempotentReplayedHeader, signatureHeader, signatureTimestampHeader},
+		AllowCredentials: allowCredentials,
+		MaxAge:           int(cfg.CORSMaxAge.Seconds()),
+	})
+	return c.Handler
+}
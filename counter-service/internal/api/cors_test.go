@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// corsResponse sends a request with origin through corsMiddleware for
+// cfg and returns the response headers
+func corsResponse(t *testing.T, cfg *config.Config, method, origin string) http.Header {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	handler := corsMiddleware(cfg, &logger)(okHandler)
+
+	req := httptest.NewRequest(method, "/api/counter", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Header()
+}
+
+// corsConfig returns a CORS config for origins with credentials on or off
+func corsConfig(credentials bool, origins ...string) *config.Config {
+	return &config.Config{
+		AllowedOrigins:       origins,
+		CORSAllowCredentials: credentials,
+		CORSAllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		CORSAllowedHeaders:   []string{"Content-Type"},
+		CORSMaxAge:           time.Minute,
+	}
+}
+
+func TestCORSEchoesAllowedOrigin(t *testing.T) {
+	cfg := corsConfig(true, "https://app.example.com", "https://admin.example.com")
+
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		h := corsResponse(t, cfg, method, "https://admin.example.com")
+		if got := h.Get("Access-Control-Allow-Origin"); got != "https://admin.example.com" {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want the request origin", method, got)
+		}
+		if got := h.Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want true", method, got)
+		}
+	}
+}
+
+func TestCORSRejectsUnlistedOrigin(t *testing.T) {
+	cfg := corsConfig(true, "https://app.example.com")
+
+	h := corsResponse(t, cfg, http.MethodGet, "https://evil.example.com")
+	if got := h.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q for an unlisted origin, want none", got)
+	}
+}
+
+func TestCORSWildcardDisablesCredentials(t *testing.T) {
+	cfg := corsConfig(true, "*")
+
+	h := corsResponse(t, cfg, http.MethodGet, "https://anywhere.example.com")
+	if got := h.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := h.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q with a wildcard origin, want none", got)
+	}
+}
+
+func TestCORSPreflightUsesConfiguredMethods(t *testing.T) {
+	cfg := corsConfig(false, "https://app.example.com")
+	cfg.CORSAllowedMethods = []string{http.MethodGet, http.MethodDelete}
+	cfg.CORSAllowedHeaders = []string{"Content-Type", "Idempotency-Key"}
+	logger := zerolog.Nop()
+	handler := corsMiddleware(cfg, &logger)(okHandler)
+
+	preflight := func(method string) http.Header {
+		req := httptest.NewRequest(http.MethodOptions, "/api/counter", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", "Idempotency-Key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	h := preflight(http.MethodDelete)
+	if got := h.Get("Access-Control-Allow-Methods"); got != http.MethodDelete {
+		t.Errorf("Access-Control-Allow-Methods = %q, want DELETE", got)
+	}
+	if got := h.Get("Access-Control-Allow-Headers"); !strings.EqualFold(got, "Idempotency-Key") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want Idempotency-Key", got)
+	}
+	if got := h.Get("Access-Control-Max-Age"); got != "60" {
+		t.Errorf("Access-Control-Max-Age = %q, want 60", got)
+	}
+
+	// POST is not in the configured list, so its preflight is refused
+	h = preflight(http.MethodPost)
+	if got := h.Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("Access-Control-Allow-Methods = %q for an unlisted method, want none", got)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bodyError is a request body that decodeJSON rejected, with the status to
+// answer it with
+type bodyError struct {
+	status  int
+	message string
+}
+
+func (e *bodyError) Error() string {
+	return e.message
+}
+
+// decodeJSON decodes the request body into dst. The body must be a single
+// JSON value with no unknown fields and nothing after it. Failures are
+// returned as a *bodyError naming the problem, and the offending field where
+// there is one.
+func decodeJSON(r *http.Request, dst interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+
+	// Anything but whitespace after the value is trailing data
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeError(err)
+		}
+		return &bodyError{status: http.StatusBadRequest, message: "Request body must contain a single JSON object"}
+	}
+	return nil
+}
+
+// unknownFieldPrefix starts the error json.Decoder returns for a field that
+// dst does not have; encoding/json has no error type for it
+const unknownFieldPrefix = "json: unknown field "
+
+// decodeError describes an error from json.Decoder.Decode
+func decodeError(err error) *bodyError {
+	var (
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+		maxBytesErr *http.MaxBytesError
+	)
+
+	var message string
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &bodyError{status: http.StatusRequestEntityTooLarge, message: "Request body too large"}
+	case errors.Is(err, io.EOF):
+		message = "Request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		message = "Request body contains malformed JSON"
+	case errors.As(err, &syntaxErr):
+		message = fmt.Sprintf("Request body contains malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		message = "Request body must be a JSON object, got " + typeErr.Value
+	case errors.As(err, &typeErr):
+		message = fmt.Sprintf("Field %q must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+		message = "Unknown field " + strings.TrimPrefix(err.Error(), unknownFieldPrefix)
+	default:
+		message = "Invalid request body: " + err.Error()
+	}
+	return &bodyError{status: http.StatusBadRequest, message: message}
+}
+
+// sendBodyError sends the error response for a body rejected by decodeJSON
+func (h *Handler) sendBodyError(w http.ResponseWriter, r *http.Request, err error, requestID string, start time.Time) {
+	var bodyErr *bodyError
+	if !errors.As(err, &bodyErr) {
+		bodyErr = decodeError(err)
+	}
+
+	code := "INVALID_BODY"
+	if bodyErr.status == http.StatusRequestEntityTooLarge {
+		code = "BODY_TOO_LARGE"
+	}
+	h.sendErrorResponse(w, r, bodyErr.status, bodyErr.message, code, requestID, start)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yourusername/counter-service/internal/counter"
+)
+
+// serviceError maps an error from the counter service to a status code,
+// message and error code. fallback is the message for unrecognised errors.
+func serviceError(err error, fallback string) (int, string, string) {
+	var persistErr *counter.PersistError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, "Request timed out", "TIMEOUT"
+	case errors.Is(err, counter.ErrReadOnly):
+		return http.StatusServiceUnavailable, "Counter persistence is read-only", "READ_ONLY"
+	case errors.Is(err, counter.ErrLockTimeout):
+		return http.StatusServiceUnavailable, "Timed out waiting for the counter file lock", "LOCK_TIMEOUT"
+	case errors.Is(err, counter.ErrDiskFull):
+		return http.StatusInsufficientStorage, "Not enough disk space to save the counter", "DISK_FULL"
+	case errors.Is(err, counter.ErrCorruptData):
+		return http.StatusInternalServerError, "Counter file is corrupt", "CORRUPT_DATA"
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, "Counter file has not been written yet", "FILE_NOT_FOUND"
+	case errors.As(err, &persistErr):
+		return http.StatusInternalServerError, "Failed to " + persistErr.Op + " counter file", "PERSIST_ERROR"
+	default:
+		return http.StatusInternalServerError, fallback, "COUNTER_ERROR"
+	}
+}
+
+// sendServiceError sends the error response for an error from the counter
+// service
+func (h *Handler) sendServiceError(w http.ResponseWriter, r *http.Request, err error, fallback string, requestID string, start time.Time) {
+	status, message, code := serviceError(err, fallback)
+	h.sendErrorResponse(w, r, status, message, code, requestID, start)
+}
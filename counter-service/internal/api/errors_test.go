@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/yourusername/counter-service/internal/counter"
+)
+
+func TestServiceError(t *testing.T) {
+	// Errors arrive wrapped, as the service returns them
+	persist := func(op string, err error) error {
+		return fmt.Errorf("save: %w", &counter.PersistError{Op: op, Path: "/data/counter.json", Err: err})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"timeout", fmt.Errorf("increment: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "TIMEOUT"},
+		{"read only", counter.ErrReadOnly, http.StatusServiceUnavailable, "READ_ONLY"},
+		{"lock timeout", persist("lock", counter.ErrLockTimeout), http.StatusServiceUnavailable, "LOCK_TIMEOUT"},
+		{"disk full", persist("write", counter.ErrDiskFull), http.StatusInsufficientStorage, "DISK_FULL"},
+		{"corrupt data", persist("load", counter.ErrCorruptData), http.StatusInternalServerError, "CORRUPT_DATA"},
+		{"checksum mismatch", persist("load", counter.ErrChecksumMismatch), http.StatusInternalServerError, "CORRUPT_DATA"},
+		{"missing file", persist("verify", os.ErrNotExist), http.StatusNotFound, "FILE_NOT_FOUND"},
+		{"other persist error", persist("rename", os.ErrPermission), http.StatusInternalServerError, "PERSIST_ERROR"},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, "COUNTER_ERROR"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, message, code := serviceError(tc.err, "Fallback message")
+			if status != tc.status || code != tc.code {
+				t.Errorf("serviceError = %d %q, want %d %q", status, code, tc.status, tc.code)
+			}
+			if message == "" {
+				t.Error("serviceError returned no message")
+			}
+		})
+	}
+
+	// Only unrecognised errors use the fallback message, and persist errors
+	// name the operation without leaking the path
+	if _, message, _ := serviceError(errors.New("boom"), "Fallback message"); message != "Fallback message" {
+		t.Errorf("Unknown error message = %q, want the fallback", message)
+	}
+	if _, message, _ := serviceError(persist("rename", os.ErrPermission), "Fallback message"); message != "Failed to rename counter file" {
+		t.Errorf("Persist error message = %q, want it to name the operation", message)
+	}
+}
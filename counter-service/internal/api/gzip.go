@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponseWriter buffers the start of a response so it can decide
+// whether the body is large enough to be worth compressing
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	minBytes int
+	buf      bytes.Buffer
+	gz       *gzip.Writer
+	decided  bool
+}
+
+// newGzipResponseWriter creates a new gzipResponseWriter
+func newGzipResponseWriter(w http.ResponseWriter, minBytes int) *gzipResponseWriter {
+	return &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK, minBytes: minBytes}
+}
+
+// WriteHeader captures the status code until the compression decision is made
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.decided {
+		return
+	}
+	gw.status = code
+}
+
+// Write buffers data until the threshold is reached, then compresses
+func (gw *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !gw.decided {
+		// Streaming responses must reach the client immediately, and
+		// bodies the handler encoded itself must not be encoded twice
+		if isStreamingContentType(gw.Header().Get("Content-Type")) || gw.Header().Get("Content-Encoding") != "" {
+			gw.passthrough()
+			if err := gw.flushBuffer(); err != nil {
+				return 0, err
+			}
+		} else {
+			gw.buf.Write(p)
+			if gw.buf.Len() < gw.minBytes {
+				return len(p), nil
+			}
+			gw.startGzip()
+			return len(p), gw.flushBuffer()
+		}
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(p)
+	}
+	return gw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, uncompressed if the threshold
+// has not been reached yet
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.passthrough()
+		gw.flushBuffer()
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close finishes the response, writing small bodies uncompressed
+func (gw *gzipResponseWriter) close() error {
+	if !gw.decided {
+		gw.passthrough()
+		if err := gw.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// startGzip commits to a compressed response
+func (gw *gzipResponseWriter) startGzip() {
+	gw.decided = true
+	h := gw.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	gw.ResponseWriter.WriteHeader(gw.status)
+	gw.gz = gzip.NewWriter(gw.ResponseWriter)
+}
+
+// passthrough commits to an uncompressed response
+func (gw *gzipResponseWriter) passthrough() {
+	gw.decided = true
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+// flushBuffer writes any buffered bytes to the chosen destination
+func (gw *gzipResponseWriter) flushBuffer() error {
+	if gw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf.Bytes())
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf.Bytes())
+	}
+	gw.buf.Reset()
+	return err
+}
+
+// isStreamingContentType reports whether a response is streamed to the client
+func isStreamingContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/event-stream") ||
+		strings.HasPrefix(contentType, "application/x-ndjson")
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.SplitN(part, ";", 2)
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		if len(fields) == 2 {
+			// An explicit zero quality value means the client refuses gzip
+			q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(fields[1]), "q="), 64)
+			if err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip.
+// Responses that already have a Content-Encoding are passed through.
+func gzipMiddleware(minBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !acceptsGzip(r) || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := newGzipResponseWriter(w, minBytes)
+			defer gw.close()
+
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipHandler(t *testing.T, handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	gzipMiddleware(16)(handler).ServeHTTP(w, req)
+	return w
+}
+
+func gunzip(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Body is not gzip: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to gunzip body: %v", err)
+	}
+	return out
+}
+
+func TestGzipMiddlewareCompressesLargeBodies(t *testing.T) {
+	body := strings.Repeat("counter ", 32)
+	w := gzipHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}, "gzip")
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if got := string(gunzip(t, w.Body.Bytes())); got != body {
+		t.Errorf("Decoded body = %q, want %q", got, body)
+	}
+}
+
+func TestGzipMiddlewareSkipsSmallBodies(t *testing.T) {
+	w := gzipHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "small")
+	}, "gzip")
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if got := w.Body.String(); got != "small" {
+		t.Errorf("Body = %q, want small", got)
+	}
+}
+
+func TestGzipMiddlewareRespectsAcceptEncoding(t *testing.T) {
+	body := strings.Repeat("counter ", 32)
+	for _, accept := range []string{"", "identity", "gzip;q=0"} {
+		w := gzipHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}, accept)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want none", accept, got)
+		}
+	}
+}
+
+func TestGzipMiddlewarePassesThroughEncodedBodies(t *testing.T) {
+	var encoded bytes.Buffer
+	zw := gzip.NewWriter(&encoded)
+	io.WriteString(zw, strings.Repeat("already compressed ", 32))
+	zw.Close()
+
+	w := gzipHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encoded.Bytes())
+	}, "gzip")
+
+	if !bytes.Equal(w.Body.Bytes(), encoded.Bytes()) {
+		t.Fatal("Body was changed, want the handler's encoding passed through")
+	}
+	if got := string(gunzip(t, w.Body.Bytes())); !strings.HasPrefix(got, "already compressed") {
+		t.Errorf("Decoded body = %q, want the original text after one gunzip", got)
+	}
+}
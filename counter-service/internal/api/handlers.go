@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"runtime"
 	"time"
@@ -9,8 +11,20 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/metrics"
 )
 
+// Idempotency headers for the increment endpoint
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// dryRunHeader, or the dryRun query parameter, makes an increment report the
+// value it would return without changing the counter
+const dryRunHeader = "X-Dry-Run"
+
 // HTTPResponse standardizes API responses
 type HTTPResponse struct {
 	Success      bool        `json:"success"`
@@ -24,14 +38,23 @@ type HTTPResponse struct {
 // Handler contains the HTTP handlers for the API
 type Handler struct {
 	counterService *counter.Service
+	metrics        *metrics.Metrics
 	logger         *zerolog.Logger
+	envelope       bool
+	signingKey     []byte
 }
 
-// NewHandler creates a new Handler instance
-func NewHandler(counterService *counter.Service, logger *zerolog.Logger) *Handler {
+// NewHandler creates a new Handler instance. With envelope set responses
+// are wrapped in HTTPResponse, otherwise they are sent flat (see
+// writeResponse). A non-empty signingKey signs the responses of successful
+// increments (see signResponse).
+func NewHandler(counterService *counter.Service, metrics *metrics.Metrics, logger *zerolog.Logger, envelope bool, signingKey string) *Handler {
 	return &Handler{
 		counterService: counterService,
+		metrics:        metrics,
 		logger:         logger,
+		envelope:       envelope,
+		signingKey:     []byte(signingKey),
 	}
 }
 
@@ -40,20 +63,19 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	requestID := r.Context().Value(requestIDKey).(string)
 
-	if r.Method != http.MethodGet {
-		h.sendErrorResponse(w, r, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED", requestID, start)
-		return
-	}
-
 	// Basic health check
 	health := map[string]interface{}{
-		"status":    "UP",
-		"timestamp": time.Now().Format(time.RFC3339),
-		"version":   config.Version,
+		"status":        "UP",
+		"timestamp":     h.counterService.Now().Format(time.RFC3339),
+		"startedAt":     h.counterService.StartTime().Format(time.RFC3339),
+		"uptimeSeconds": h.counterService.Uptime().Seconds(),
+		"version":       config.Version,
 		"buildInfo": map[string]string{
 			"goVersion": runtime.Version(),
+			"gitCommit": config.GitCommit,
 			"platform":  runtime.GOOS + "/" + runtime.GOARCH,
 		},
+		"persistence": persistenceInfo(h.counterService.PersistStatus()),
 	}
 
 	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
@@ -64,27 +86,175 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ReadinessCheck handles the readiness endpoint. The service reports not
+// ready once saves have failed enough times in a row to degrade persistence,
+// or when it is read-only, so traffic moves away from an instance that can
+// no longer write its counter to disk. A single failed save is reported in
+// the body only, since the next one often succeeds.
+func (h *Handler) ReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	status := h.counterService.PersistStatus()
+	if status.ReadOnly {
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Counter directory is not writable, increments are not being saved", "READ_ONLY", requestID, start)
+		return
+	}
+	if status.Degraded {
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Counter saves keep failing, increments are held in memory", "DEGRADED", requestID, start)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"status":      "READY",
+			"persistence": persistenceInfo(status),
+		},
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// Warmup handles the warmup endpoint. Unlike the readiness check it does
+// I/O, reading the counter file and probing its directory for writes, so
+// load balancers only route traffic once the persistence path works.
+func (h *Handler) Warmup(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	if err := h.counterService.Warmup(r.Context()); err != nil {
+		// Any failure means the instance should not get traffic yet
+		_, message, code := serviceError(err, "Warmup failed")
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, message, code, requestID, start)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"status": "WARM",
+		},
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// persistenceInfo formats a persist status for health responses
+func persistenceInfo(status counter.PersistStatus) map[string]interface{} {
+	info := map[string]interface{}{
+		"dirty": status.Dirty,
+	}
+	if status.ReadOnly {
+		info["readOnly"] = true
+	}
+	if status.Degraded {
+		info["degraded"] = true
+	}
+	if status.ConsecutiveFailures > 0 {
+		info["consecutiveFailures"] = status.ConsecutiveFailures
+	}
+	if !status.LastPersistTime.IsZero() {
+		info["lastPersist"] = status.LastPersistTime.Format(time.RFC3339)
+		info["ageSeconds"] = status.Age.Seconds()
+	}
+	if status.LastPersistErr != nil {
+		_, _, code := serviceError(status.LastPersistErr, "")
+		info["lastError"] = status.LastPersistErr.Error()
+		info["lastErrorCode"] = code
+	}
+	return info
+}
+
 // IncrementCounter handles the counter increment endpoint
 func (h *Handler) IncrementCounter(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	requestID := r.Context().Value(requestIDKey).(string)
 
-	if r.Method != http.MethodPost {
-		h.sendErrorResponse(w, r, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED", requestID, start)
+	if r.URL.Query().Get("dryRun") == "true" || r.Header.Get(dryRunHeader) == "true" {
+		h.dryRunIncrement(w, r, requestID, start)
+		return
+	}
+
+	// Retried requests with the same key return the original result
+	key := r.Header.Get(idempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		h.sendErrorResponse(w, r, http.StatusBadRequest, "Idempotency key too long", "INVALID_IDEMPOTENCY_KEY", requestID, start)
 		return
 	}
 
 	// Increment counter
-	newValue, err := h.counterService.Increment()
+	newValue, replayed, err := h.counterService.IncrementIdempotent(r.Context(), key)
+	durable := true
+	if errors.Is(err, counter.ErrNotDurable) {
+		h.logNotDurable(r, err, requestID)
+		durable, err = false, nil
+	}
+	if errors.Is(err, counter.ErrCounterOverflow) {
+		h.sendErrorResponse(w, r, http.StatusConflict, "Counter is at its maximum value", "OVERFLOW", requestID, start)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Request timed out", "TIMEOUT", requestID, start)
+		return
+	}
 	if err != nil {
-		h.sendErrorResponse(w, r, http.StatusInternalServerError, "Failed to increment counter", "COUNTER_ERROR", requestID, start)
+		h.sendServiceError(w, r, err, "Failed to increment counter", requestID, start)
 		return
 	}
+	if replayed {
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
 
-	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+	h.sendSignedJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success:      true,
+		Data:         incrementData(newValue, durable),
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// incrementData returns the response data for an increment to newValue.
+// Increments that were applied but not saved still succeed, flagged with
+// "durable": false, because failing them would invite a retry that
+// increments twice.
+func incrementData(newValue int64, durable bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"visits": newValue,
+	}
+	if !durable {
+		data["durable"] = false
+	}
+	return data
+}
+
+// logNotDurable logs an increment that was applied but could not be saved
+func (h *Handler) logNotDurable(r *http.Request, err error, requestID string) {
+	h.logger.Warn().
+		Err(err).
+		Str("path", r.URL.Path).
+		Str("requestID", requestID).
+		Msg("Increment applied but not saved")
+}
+
+// dryRunIncrement answers an increment request with the value it would
+// return, leaving the counter and the idempotency cache untouched
+func (h *Handler) dryRunIncrement(w http.ResponseWriter, r *http.Request, requestID string, start time.Time) {
+	newValue, err := h.counterService.DryRunIncrement(r.Context())
+	if errors.Is(err, counter.ErrCounterOverflow) {
+		h.sendErrorResponse(w, r, http.StatusConflict, "Counter is at its maximum value", "OVERFLOW", requestID, start)
+		return
+	}
+	if err != nil {
+		h.sendServiceError(w, r, err, "Failed to increment counter", requestID, start)
+		return
+	}
+
+	h.sendSignedJSONResponse(w, http.StatusOK, HTTPResponse{
 		Success: true,
 		Data: map[string]interface{}{
 			"visits": newValue,
+			"dryRun": true,
 		},
 		RequestID:    requestID,
 		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
@@ -96,37 +266,291 @@ func (h *Handler) GetCounter(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	requestID := r.Context().Value(requestIDKey).(string)
 
-	if r.Method != http.MethodGet {
-		h.sendErrorResponse(w, r, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED", requestID, start)
+	// Get counter value
+	value, err := h.counterService.GetValue(r.Context())
+	if errors.Is(err, context.DeadlineExceeded) {
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Request timed out", "TIMEOUT", requestID, start)
 		return
 	}
-
-	// Get counter value
-	value, err := h.counterService.GetValue()
 	if err != nil {
 		h.sendErrorResponse(w, r, http.StatusInternalServerError, "Failed to get counter", "COUNTER_ERROR", requestID, start)
 		return
 	}
 
+	data := map[string]interface{}{
+		"visits": value,
+		"peak":   h.counterService.Peak(),
+	}
+	if r.URL.Query().Get("rate") == "true" {
+		data["rate"] = h.counterService.IncrementRate()
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success:      true,
+		Data:         data,
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// CompareAndIncrementRequest is the body accepted by the conditional
+// increment endpoint
+type CompareAndIncrementRequest struct {
+	// Expected is the value the counter must have for the increment to apply
+	Expected *int64 `json:"expected"`
+}
+
+// CompareAndIncrement handles the conditional increment endpoint
+func (h *Handler) CompareAndIncrement(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	var req CompareAndIncrementRequest
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendBodyError(w, r, err, requestID, start)
+		return
+	}
+	if req.Expected == nil {
+		h.sendErrorResponse(w, r, http.StatusBadRequest, "Request body must contain an expected value", "INVALID_BODY", requestID, start)
+		return
+	}
+
+	newValue, err := h.counterService.CompareAndIncrement(r.Context(), *req.Expected)
+	durable := true
+	if errors.Is(err, counter.ErrNotDurable) {
+		h.logNotDurable(r, err, requestID)
+		durable, err = false, nil
+	}
+	switch {
+	case errors.Is(err, counter.ErrValueMismatch):
+		// A mismatch is an expected outcome, so report the actual value
+		// for the client to retry with instead of logging an error
+		h.sendJSONResponse(w, http.StatusConflict, HTTPResponse{
+			Success: false,
+			Data: map[string]interface{}{
+				"visits": newValue,
+			},
+			Error:        "Counter value does not match the expected value",
+			ErrorCode:    "VALUE_MISMATCH",
+			RequestID:    requestID,
+			ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+		})
+		return
+	case errors.Is(err, counter.ErrCounterOverflow):
+		h.sendErrorResponse(w, r, http.StatusConflict, "Counter is at its maximum value", "OVERFLOW", requestID, start)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Request timed out", "TIMEOUT", requestID, start)
+		return
+	case err != nil:
+		h.sendServiceError(w, r, err, "Failed to increment counter", requestID, start)
+		return
+	}
+
+	h.sendSignedJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success:      true,
+		Data:         incrementData(newValue, durable),
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// VerifyCounter handles the counter file verification endpoint
+func (h *Handler) VerifyCounter(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	valid, err := h.counterService.Verify(r.Context())
+	if err != nil {
+		h.sendServiceError(w, r, err, "Failed to verify counter file", requestID, start)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"valid": valid,
+		},
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// ImportRequest is the body accepted by the import endpoint
+type ImportRequest struct {
+	// Overwrite must be true to confirm the current value will be replaced
+	Overwrite bool                `json:"overwrite"`
+	Data      counter.CounterData `json:"data"`
+}
+
+// ExportCounter handles the counter export endpoint
+func (h *Handler) ExportCounter(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	data, err := h.counterService.Export(r.Context())
+	if errors.Is(err, context.DeadlineExceeded) {
+		h.sendErrorResponse(w, r, http.StatusServiceUnavailable, "Request timed out", "TIMEOUT", requestID, start)
+		return
+	}
+	if err != nil {
+		h.sendErrorResponse(w, r, http.StatusInternalServerError, "Failed to export counter", "COUNTER_ERROR", requestID, start)
+		return
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success:      true,
+		Data:         data,
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// ImportCounter handles the counter import endpoint
+func (h *Handler) ImportCounter(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	var req ImportRequest
+	if err := decodeJSON(r, &req); err != nil {
+		h.sendBodyError(w, r, err, requestID, start)
+		return
+	}
+	if !req.Overwrite {
+		h.sendErrorResponse(w, r, http.StatusBadRequest, "Import replaces the current value, set \"overwrite\": true to confirm", "CONFIRMATION_REQUIRED", requestID, start)
+		return
+	}
+
+	err := h.counterService.Import(r.Context(), req.Data)
+	switch {
+	case errors.Is(err, counter.ErrChecksumMismatch), errors.Is(err, counter.ErrVersionMismatch):
+		h.sendErrorResponse(w, r, http.StatusUnprocessableEntity, err.Error(), "INVALID_STATE", requestID, start)
+		return
+	case errors.Is(err, counter.ErrCounterOverflow):
+		h.sendErrorResponse(w, r, http.StatusConflict, "Imported value exceeds the maximum value", "OVERFLOW", requestID, start)
+		return
+	case err != nil:
+		h.sendServiceError(w, r, err, "Failed to import counter", requestID, start)
+		return
+	}
+
 	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
 		Success: true,
 		Data: map[string]interface{}{
-			"visits": value,
+			"visits": req.Data.Visits,
 		},
 		RequestID:    requestID,
 		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
 	})
 }
 
+// Stats handles the JSON metrics summary endpoint, for dashboards that
+// cannot scrape Prometheus
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID := r.Context().Value(requestIDKey).(string)
+
+	summary, err := h.metrics.Summary()
+	if err != nil {
+		h.sendErrorResponse(w, r, http.StatusInternalServerError, "Failed to gather metrics", "METRICS_ERROR", requestID, start)
+		return
+	}
+
+	data := map[string]interface{}{
+		"visits":        int64(summary.CounterValue),
+		"increments":    int64(summary.Increments),
+		"gets":          int64(summary.Gets),
+		"imports":       int64(summary.Imports),
+		"requests":      int64(summary.Requests),
+		"errors":        int64(summary.RequestErrors),
+		"persistErrors": int64(summary.PersistErrors),
+	}
+	if !summary.StartTime.IsZero() {
+		data["startedAt"] = summary.StartTime.UTC().Format(time.RFC3339)
+		data["uptimeSeconds"] = time.Since(summary.StartTime).Seconds()
+	}
+	if !summary.LastPersistTime.IsZero() {
+		data["lastPersist"] = summary.LastPersistTime.UTC().Format(time.RFC3339)
+	}
+
+	h.sendJSONResponse(w, http.StatusOK, HTTPResponse{
+		Success:      true,
+		Data:         data,
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// NotFound answers requests for unknown paths with a JSON 404. It is not
+// logged as an error, since scanners and typos are routine.
+func (h *Handler) NotFound(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+
+	if rw, ok := w.(*responseWriter); ok {
+		rw.unmatched = true
+	}
+
+	h.sendJSONResponse(w, http.StatusNotFound, HTTPResponse{
+		Success:      false,
+		Error:        "Not found",
+		ErrorCode:    "NOT_FOUND",
+		RequestID:    requestID,
+		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
+	})
+}
+
+// MethodNotAllowed answers requests with a method the route does not
+// accept. The caller sets the Allow header.
+func (h *Handler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+	h.sendErrorResponse(w, r, http.StatusMethodNotAllowed, "Method not allowed", "METHOD_NOT_ALLOWED", requestID, time.Now())
+}
+
+// Favicon answers browser favicon requests with no content, keeping them
+// out of the 404s
+func (h *Handler) Favicon(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // sendJSONResponse sends a JSON response with the provided status code
 func (h *Handler) sendJSONResponse(w http.ResponseWriter, statusCode int, response HTTPResponse) {
+	if err := writeResponse(w, statusCode, response, h.envelope); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// writeResponse writes response as JSON with statusCode. With envelope
+// unset the wrapper is dropped and the status alone signals success:
+// successes send just the data, and errors send {"error", "error_code"}
+// merged with any data.
+func writeResponse(w http.ResponseWriter, statusCode int, response HTTPResponse, envelope bool) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(responseBody(response, envelope))
+}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to encode response")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+// responseBody returns the value writeResponse encodes for response
+func responseBody(response HTTPResponse, envelope bool) interface{} {
+	if envelope {
+		return response
+	}
+	if response.Success {
+		return response.Data
+	}
+
+	body := map[string]interface{}{}
+	if data, ok := response.Data.(map[string]interface{}); ok {
+		for k, v := range data {
+			body[k] = v
+		}
+	}
+	body["error"] = response.Error
+	if response.ErrorCode != "" {
+		body["error_code"] = response.ErrorCode
 	}
+	return body
 }
 
 // sendErrorResponse sends an error response with the provided status code
@@ -147,4 +571,4 @@ func (h *Handler) sendErrorResponse(w http.ResponseWriter, r *http.Request, stat
 		RequestID:    requestID,
 		ResponseTime: float64(time.Since(start).Microseconds()) / 1000.0,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,600 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/api"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/test"
+)
+
+// newTestAPI returns the full API handler for cfg and the service behind
+// it, on a fake clock so background saves only happen when the test asks
+func newTestAPI(t *testing.T, cfg *config.Config) (http.Handler, *counter.Service) {
+	t.Helper()
+
+	logger := test.NewTestLogger()
+	metrics := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), logger, metrics)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	t.Cleanup(func() {
+		service.Shutdown()
+	})
+	return api.NewServer(cfg, logger, service, metrics).Handler(), service
+}
+
+// decodeResponse decodes an enveloped response body
+func decodeResponse(t *testing.T, body []byte) api.HTTPResponse {
+	t.Helper()
+
+	var response api.HTTPResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", body, err)
+	}
+	return response
+}
+
+func TestReadinessIgnoresIsolatedSaveFailures(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.DegradeAfterFailures = 3
+	handler, service := newTestAPI(t, cfg)
+
+	// Make saves fail by replacing the counter directory with a file
+	dir := filepath.Dir(cfg.Filename)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Increment(context.Background()); err != nil {
+		t.Fatalf("Increment failed: %v", err)
+	}
+
+	for i := 1; i <= cfg.DegradeAfterFailures; i++ {
+		if err := service.Persist(context.Background()); err == nil {
+			t.Fatal("Persist succeeded, want a failure")
+		}
+
+		w := test.PerformRequest(t, http.MethodGet, "/ready", nil, handler)
+		response := decodeResponse(t, w.Body.Bytes())
+		if i < cfg.DegradeAfterFailures {
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status after %d failures = %d, want 200", i, w.Code)
+			}
+			persistence := response.Data.(map[string]interface{})["persistence"].(map[string]interface{})
+			if persistence["lastError"] == nil || persistence["lastErrorCode"] != "PERSIST_ERROR" {
+				t.Errorf("Persistence after %d failures = %v, want the latest error reported", i, persistence)
+			}
+			continue
+		}
+
+		if w.Code != http.StatusServiceUnavailable || response.ErrorCode != "DEGRADED" {
+			t.Errorf("Response once degraded = %d %q, want 503 DEGRADED", w.Code, response.ErrorCode)
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.EnableStateImport = true
+	source, _ := newTestAPI(t, cfg)
+	for i := 0; i < 3; i++ {
+		test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, source)
+	}
+
+	w := test.PerformRequest(t, http.MethodGet, "/api/counter/export", nil, source)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Export = %d %s, want 200", w.Code, w.Body)
+	}
+	var exported struct {
+		Data counter.CounterData `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import into a fresh service, as when moving between environments
+	targetCfg := test.NewTestConfig(t)
+	targetCfg.EnableStateImport = true
+	target, service := newTestAPI(t, targetCfg)
+	w = test.PerformRequest(t, http.MethodPost, "/api/counter/import", api.ImportRequest{Data: exported.Data}, target)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusBadRequest || response.ErrorCode != "CONFIRMATION_REQUIRED" {
+		t.Fatalf("Import without overwrite = %d %q, want 400 CONFIRMATION_REQUIRED", w.Code, response.ErrorCode)
+	}
+
+	tampered := exported.Data
+	tampered.Visits++
+	w = test.PerformRequest(t, http.MethodPost, "/api/counter/import", api.ImportRequest{Overwrite: true, Data: tampered}, target)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusUnprocessableEntity || response.ErrorCode != "INVALID_STATE" {
+		t.Fatalf("Import with a bad checksum = %d %q, want 422 INVALID_STATE", w.Code, response.ErrorCode)
+	}
+	if visits, _ := service.GetValue(context.Background()); visits != 0 {
+		t.Fatalf("Counter = %d after rejected imports, want 0", visits)
+	}
+
+	w = test.PerformRequest(t, http.MethodPost, "/api/counter/import", api.ImportRequest{Overwrite: true, Data: exported.Data}, target)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Import = %d %s, want 200", w.Code, w.Body)
+	}
+	if visits, _ := service.GetValue(context.Background()); visits != 3 {
+		t.Errorf("Counter = %d after import, want 3", visits)
+	}
+
+	// Import persists immediately
+	saved, err := counter.LoadCounter(targetCfg, test.NewTestLogger(), test.NewTestMetrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.GetValue() != 3 {
+		t.Errorf("Saved counter = %d after import, want 3", saved.GetValue())
+	}
+}
+
+func TestIncrementOverflowConflict(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.MaxValue = 2
+	handler, _ := newTestAPI(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		if w := test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler); w.Code != http.StatusOK {
+			t.Fatalf("Increment %d = %d, want 200", i+1, w.Code)
+		}
+	}
+
+	w := test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusConflict || response.ErrorCode != "OVERFLOW" {
+		t.Errorf("Increment past max = %d %q, want 409 OVERFLOW", w.Code, response.ErrorCode)
+	}
+}
+
+func TestCompareAndIncrementHandler(t *testing.T) {
+	handler, _ := newTestAPI(t, test.NewTestConfig(t))
+	path := "/api/counter/compare-and-increment"
+
+	w := test.PerformRequest(t, http.MethodPost, path, map[string]int64{"expected": 0}, handler)
+	response := decodeResponse(t, w.Body.Bytes())
+	if w.Code != http.StatusOK || response.Data.(map[string]interface{})["visits"] != float64(1) {
+		t.Fatalf("Matching CAS = %d %s, want 200 with visits 1", w.Code, w.Body)
+	}
+
+	// The stale value fails with the actual value for the client to retry with
+	w = test.PerformRequest(t, http.MethodPost, path, map[string]int64{"expected": 0}, handler)
+	response = decodeResponse(t, w.Body.Bytes())
+	if w.Code != http.StatusConflict || response.ErrorCode != "VALUE_MISMATCH" {
+		t.Fatalf("Stale CAS = %d %q, want 409 VALUE_MISMATCH", w.Code, response.ErrorCode)
+	}
+	if visits := response.Data.(map[string]interface{})["visits"]; visits != float64(1) {
+		t.Errorf("Mismatch reported visits = %v, want the actual value 1", visits)
+	}
+
+	w = test.PerformRequest(t, http.MethodPost, path, map[string]string{}, handler)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("CAS without expected = %d, want 400", w.Code)
+	}
+}
+
+func TestVerifyCounterHandler(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	handler, service := newTestAPI(t, cfg)
+	ctx := context.Background()
+	service.Increment(ctx)
+	if err := service.Persist(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() bool {
+		t.Helper()
+		w := test.PerformRequest(t, http.MethodGet, "/api/counter/verify", nil, handler)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Verify = %d %s, want 200", w.Code, w.Body)
+		}
+		return decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})["valid"].(bool)
+	}
+
+	if !verify() {
+		t.Error("Freshly saved counter file reported invalid")
+	}
+
+	// Flip a digit in the saved file so the CRC no longer matches
+	content, err := os.ReadFile(cfg.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = regexp.MustCompile(`("visits":\s*)1`).ReplaceAll(content, []byte("${1}7"))
+	if err := os.WriteFile(cfg.Filename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if verify() {
+		t.Errorf("Tampered counter file %s reported valid", content)
+	}
+}
+
+func TestStatsAfterActivity(t *testing.T) {
+	handler, service := newTestAPI(t, test.NewTestConfig(t))
+	test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	test.PerformRequest(t, http.MethodGet, "/api/counter", nil, handler)
+	if err := service.Persist(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := test.PerformRequest(t, http.MethodGet, "/api/stats", nil, handler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Stats = %d %s, want 200", w.Code, w.Body)
+	}
+	stats := decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})
+
+	for _, key := range []string{"visits", "increments", "gets", "requests", "errors", "persistErrors", "startedAt", "uptimeSeconds", "lastPersist"} {
+		if _, ok := stats[key]; !ok {
+			t.Errorf("Stats have no %q: %v", key, stats)
+		}
+	}
+	if stats["visits"] != float64(2) || stats["increments"] != float64(2) || stats["gets"] != float64(1) {
+		t.Errorf("Stats = %v, want 2 visits from 2 increments and 1 get", stats)
+	}
+	// Requests are counted as they finish, so the stats request is not yet
+	if requests, _ := stats["requests"].(float64); requests < 3 {
+		t.Errorf("Requests = %v, want at least the 3 made before", stats["requests"])
+	}
+}
+
+func TestStatsOperationCounts(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.EnableStateImport = true
+	handler, service := newTestAPI(t, cfg)
+
+	test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	expected := int64(2)
+	test.PerformRequest(t, http.MethodPost, "/api/counter/compare-and-increment", api.CompareAndIncrementRequest{Expected: &expected}, handler)
+	// A mismatch does not add to the counter, so it is not an increment
+	test.PerformRequest(t, http.MethodPost, "/api/counter/compare-and-increment", api.CompareAndIncrementRequest{Expected: &expected}, handler)
+	for i := 0; i < 4; i++ {
+		test.PerformRequest(t, http.MethodGet, "/api/counter", nil, handler)
+	}
+	data, err := service.Export(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := test.PerformRequest(t, http.MethodPost, "/api/counter/import", api.ImportRequest{Overwrite: true, Data: data}, handler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Import = %d %s, want 200", w.Code, w.Body)
+	}
+
+	w = test.PerformRequest(t, http.MethodGet, "/api/stats", nil, handler)
+	stats := decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})
+	for key, want := range map[string]float64{"visits": 3, "increments": 3, "gets": 4, "imports": 1} {
+		if stats[key] != want {
+			t.Errorf("Stats %s = %v, want %v", key, stats[key], want)
+		}
+	}
+}
+
+func TestDryRunIncrement(t *testing.T) {
+	handler, service := newTestAPI(t, test.NewTestConfig(t))
+	test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+
+	query := httptest.NewRequest(http.MethodPost, "/api/counter/increment?dryRun=true", nil)
+	header := httptest.NewRequest(http.MethodPost, "/api/counter/increment", nil)
+	header.Header.Set("X-Dry-Run", "true")
+	for name, req := range map[string]*http.Request{"query": query, "header": header} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Dry run by %s = %d %s, want 200", name, w.Code, w.Body)
+		}
+		data := decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})
+		if data["visits"] != float64(2) || data["dryRun"] != true {
+			t.Errorf("Dry run by %s = %v, want visits 2 and dryRun true", name, data)
+		}
+	}
+
+	if visits, _ := service.GetValue(context.Background()); visits != 1 {
+		t.Errorf("Counter = %d after dry runs, want 1", visits)
+	}
+	w := test.PerformRequest(t, http.MethodGet, "/api/stats", nil, handler)
+	stats := decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})
+	if stats["increments"] != float64(1) {
+		t.Errorf("Increments = %v after one increment and two dry runs, want 1", stats["increments"])
+	}
+}
+
+func TestMalformedBodies(t *testing.T) {
+	handler, service := newTestAPI(t, test.NewTestConfig(t))
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"unknown field", `{"expected": 0, "expectd": 1}`, `Unknown field "expectd"`},
+		{"wrong type", `{"expected": "zero"}`, `Field "expected" must be int64, got string`},
+		{"trailing garbage", `{"expected": 0} {"expected": 0}`, "Request body must contain a single JSON object"},
+		{"not an object", `[0]`, "Request body must be a JSON object, got array"},
+		{"empty", ``, "Request body is empty"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/counter/compare-and-increment", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			response := decodeResponse(t, w.Body.Bytes())
+			if w.Code != http.StatusBadRequest || response.ErrorCode != "INVALID_BODY" {
+				t.Fatalf("Body %q = %d %q, want 400 INVALID_BODY", tc.body, w.Code, response.ErrorCode)
+			}
+			if response.Error != tc.message {
+				t.Errorf("Error = %q, want %q", response.Error, tc.message)
+			}
+		})
+	}
+
+	if visits, _ := service.GetValue(context.Background()); visits != 0 {
+		t.Errorf("Counter = %d after rejected bodies, want 0", visits)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	handler, _ := newTestAPI(t, cfg)
+
+	w := test.PerformRequest(t, http.MethodGet, "/warmup", nil, handler)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusOK || !response.Success {
+		t.Fatalf("Warmup after init = %d %s, want 200", w.Code, w.Body)
+	}
+
+	// The volume stops accepting writes: a directory where the write probe
+	// goes fails it even for root
+	if err := os.MkdirAll(filepath.Join(cfg.Filename+".probe", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w = test.PerformRequest(t, http.MethodGet, "/warmup", nil, handler)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusServiceUnavailable || response.Success {
+		t.Errorf("Warmup on a non-writable volume = %d %s, want 503", w.Code, w.Body)
+	}
+
+	// A service that started read-only is never warm
+	readOnlyCfg := test.NewTestConfig(t)
+	readOnlyCfg.ReadOnlyFallback = true
+	if err := os.MkdirAll(filepath.Join(readOnlyCfg.Filename+".probe", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, _ := newTestAPI(t, readOnlyCfg)
+	w = test.PerformRequest(t, http.MethodGet, "/warmup", nil, readOnly)
+	if response := decodeResponse(t, w.Body.Bytes()); w.Code != http.StatusServiceUnavailable || response.ErrorCode != "READ_ONLY" {
+		t.Errorf("Warmup when read-only = %d %q, want 503 READ_ONLY", w.Code, response.ErrorCode)
+	}
+}
+
+func TestSignedIncrementResponse(t *testing.T) {
+	const key = "shared-secret"
+	cfg := test.NewTestConfig(t)
+	cfg.ResponseSigningKey = key
+	handler, service := newTestAPI(t, cfg)
+
+	w := test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Increment = %d %s, want 200", w.Code, w.Body)
+	}
+
+	timestamp := w.Header().Get("X-Signature-Timestamp")
+	if want := fmt.Sprint(service.Now().Unix()); timestamp != want {
+		t.Errorf("X-Signature-Timestamp = %q, want %q from the service clock", timestamp, want)
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "." + w.Body.String()))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); w.Header().Get("X-Signature") != want {
+		t.Errorf("X-Signature = %q, want %q", w.Header().Get("X-Signature"), want)
+	}
+
+	// Other responses are not signed
+	w = test.PerformRequest(t, http.MethodGet, "/api/counter", nil, handler)
+	if sig := w.Header().Get("X-Signature"); sig != "" {
+		t.Errorf("GET /api/counter carries X-Signature %q, want none", sig)
+	}
+
+	// Without a key increments are not signed either
+	unsigned, _ := newTestAPI(t, test.NewTestConfig(t))
+	w = test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, unsigned)
+	if sig := w.Header().Get("X-Signature"); sig != "" {
+		t.Errorf("Increment without a key carries X-Signature %q, want none", sig)
+	}
+}
+
+func TestHealthCheckReportsUptime(t *testing.T) {
+	handler, _ := newTestAPI(t, test.NewTestConfig(t))
+
+	w := test.PerformRequest(t, http.MethodGet, "/health", nil, handler)
+	health := decodeResponse(t, w.Body.Bytes()).Data.(map[string]interface{})
+	if uptime, ok := health["uptimeSeconds"].(float64); !ok || uptime < 0 {
+		t.Errorf("uptimeSeconds = %v, want a non-negative number", health["uptimeSeconds"])
+	}
+	if _, err := time.Parse(time.RFC3339, fmt.Sprint(health["startedAt"])); err != nil {
+		t.Errorf("startedAt = %v, want an RFC 3339 time: %v", health["startedAt"], err)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the service's background goroutines
+// to log to while the test reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestSaveFailureLogCarriesRequestID(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = 0 // save within the request
+	cfg.SaveRetryAttempts = 1
+
+	var logs lockedBuffer
+	logger := zerolog.New(&logs)
+	metrics := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), &logger, metrics)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+	handler := api.NewServer(cfg, &logger, service, metrics).Handler()
+
+	// Make saves fail by replacing the counter directory with a file
+	dir := filepath.Dir(cfg.Filename)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/counter/increment", nil)
+	req.Header.Set("X-Request-ID", "req-save-failure")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The failure is logged by the persistence layer, not just the handler
+	for _, line := range bytes.Split(logs.Bytes(), []byte("\n")) {
+		var entry map[string]interface{}
+		if json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		if entry["message"] == "Failed to save counter after multiple attempts" {
+			if entry["requestID"] != "req-save-failure" {
+				t.Errorf("Save failure logged with requestID %v, want req-save-failure: %s", entry["requestID"], line)
+			}
+			return
+		}
+	}
+	t.Errorf("No save failure logged:\n%s", logs.Bytes())
+}
+
+func TestEnvelopeModes(t *testing.T) {
+	for _, envelope := range []bool{true, false} {
+		cfg := test.NewTestConfig(t)
+		cfg.EnvelopeResponses = envelope
+		handler, _ := newTestAPI(t, cfg)
+
+		var ok map[string]interface{}
+		w := test.PerformRequest(t, http.MethodPost, "/api/counter/increment", nil, handler)
+		if err := json.Unmarshal(w.Body.Bytes(), &ok); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("envelope %v: increment = %d %s", envelope, w.Code, w.Body)
+		}
+
+		var failed map[string]interface{}
+		w = test.PerformRequest(t, http.MethodPost, "/api/counter/compare-and-increment", map[string]int64{"expected": 0}, handler)
+		if err := json.Unmarshal(w.Body.Bytes(), &failed); err != nil || w.Code != http.StatusConflict {
+			t.Fatalf("envelope %v: stale CAS = %d %s", envelope, w.Code, w.Body)
+		}
+
+		if envelope {
+			data, _ := ok["data"].(map[string]interface{})
+			if ok["success"] != true || data["visits"] != float64(1) || ok["request_id"] == nil {
+				t.Errorf("Wrapped success = %v, want success with data.visits 1", ok)
+			}
+			if failed["success"] != false || failed["error_code"] != "VALUE_MISMATCH" || failed["error"] == nil {
+				t.Errorf("Wrapped error = %v, want success false with the error code", failed)
+			}
+			continue
+		}
+
+		// Flat responses carry only the payload; the status says whether it worked
+		if ok["visits"] != float64(1) || ok["success"] != nil || ok["data"] != nil {
+			t.Errorf("Flat success = %v, want just the payload", ok)
+		}
+		if failed["error_code"] != "VALUE_MISMATCH" || failed["error"] == nil || failed["visits"] != float64(1) || failed["success"] != nil {
+			t.Errorf("Flat error = %v, want the error, code and payload without the wrapper", failed)
+		}
+	}
+}
+
+func TestUnknownPathIsJSON404(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	metrics := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), test.NewTestLogger(), metrics)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+	handler := api.NewServer(cfg, test.NewTestLogger(), service, metrics).Handler()
+
+	w := test.PerformRequest(t, http.MethodGet, "/wp-admin/setup.php", nil, handler)
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unknown path = %d %q, want a JSON 404", w.Code, w.Header().Get("Content-Type"))
+	}
+	response := decodeResponse(t, w.Body.Bytes())
+	if response.Success || response.ErrorCode != "NOT_FOUND" || response.RequestID == "" {
+		t.Errorf("Response = %+v, want the NOT_FOUND envelope", response)
+	}
+
+	// Still counted, under one label for all unknown paths
+	var d dto.Metric
+	if err := metrics.RequestsTotal.WithLabelValues(http.MethodGet, "unmatched", "404").Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Unmatched 404 requests = %v, want 1", got)
+	}
+
+	w = test.PerformRequest(t, http.MethodGet, "/favicon.ico", nil, handler)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("Favicon = %d with %d bytes, want an empty 204", w.Code, w.Body.Len())
+	}
+}
+
+func TestWrongMethodIsJSON405(t *testing.T) {
+	handler, service := newTestAPI(t, test.NewTestConfig(t))
+
+	for _, tc := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodGet, "/api/counter/increment", "POST"},
+		{http.MethodDelete, "/api/counter", "GET, HEAD"},
+	} {
+		w := test.PerformRequest(t, tc.method, tc.path, nil, handler)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s = %d, want 405", tc.method, tc.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+		if response := decodeResponse(t, w.Body.Bytes()); response.Success || response.ErrorCode != "METHOD_NOT_ALLOWED" {
+			t.Errorf("%s %s: response = %+v, want the METHOD_NOT_ALLOWED envelope", tc.method, tc.path, response)
+		}
+	}
+
+	// The rejected GET did not increment
+	if visits, _ := service.GetValue(context.Background()); visits != 0 {
+		t.Errorf("Counter = %d after a rejected method, want 0", visits)
+	}
+}
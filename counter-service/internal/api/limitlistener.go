@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// limitListener bounds the number of open connections. Unlike
+// netutil.LimitListener, which stops accepting and leaves excess clients
+// queued in the backlog, it accepts and immediately closes connections over
+// the limit, so a flood is shed and counted rather than piling up.
+type limitListener struct {
+	net.Listener
+	sem      chan struct{}
+	rejected prometheus.Counter
+}
+
+// newLimitListener wraps l to allow at most max open connections
+func newLimitListener(l net.Listener, max int, rejected prometheus.Counter) net.Listener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, max),
+		rejected: rejected,
+	}
+}
+
+// Accept returns the next connection that fits within the limit, closing
+// any that do not
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+		default:
+			l.rejected.Inc()
+			conn.Close()
+		}
+	}
+}
+
+// limitConn gives its slot back to the listener when closed
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close closes the connection and frees its slot, once
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestLimitListener(t *testing.T) {
+	base, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"})
+	ln := newLimitListener(base, 2, rejected)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", base.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	acceptedConn := func() net.Conn {
+		t.Helper()
+		select {
+		case conn := <-accepted:
+			return conn
+		case <-time.After(time.Second):
+			t.Fatal("Connection within the limit was not accepted")
+			return nil
+		}
+	}
+
+	dial()
+	first := acceptedConn()
+	dial()
+	acceptedConn()
+
+	// The third connection is over the limit and closed straight away
+	excess := dial()
+	excess.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := excess.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read on the excess connection = %v, want EOF", err)
+	}
+	select {
+	case <-accepted:
+		t.Error("Connection over the limit was accepted")
+	default:
+	}
+	var d dto.Metric
+	if err := rejected.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Rejected connections = %v, want 1", got)
+	}
+
+	// Closing a connection frees its slot, even if closed twice
+	first.Close()
+	first.Close()
+	dial()
+	acceptedConn()
+	excess = dial()
+	excess.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := excess.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read on the connection over the limit again = %v, want EOF", err)
+	}
+}
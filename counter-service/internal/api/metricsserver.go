@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves the Prometheus metrics, behind Basic auth when
+// credentials are configured. On the API port the gzip middleware does the
+// compressing, so promhttp leaves it to that.
+func (s *Server) metricsHandler() http.Handler {
+	var handler http.Handler = promhttp.HandlerFor(s.metrics.Registry, promhttp.HandlerOpts{
+		DisableCompression: s.config.EnableGzip && !s.separateMetrics(),
+	})
+
+	// Metrics stay open unless credentials are configured
+	if s.config.MetricsUser != "" && s.config.MetricsPassword != "" {
+		handler = basicAuthMiddleware("metrics", s.config.MetricsUser, s.config.MetricsPassword, s.config.EnvelopeResponses)(handler)
+	} else if s.config.MetricsUser != "" || s.config.MetricsPassword != "" {
+		s.logger.Warn().Msg("Only one of metricsUser and metricsPassword is set, /metrics is not protected")
+	}
+	return handler
+}
+
+// separateMetrics reports whether /metrics is served on its own listener
+// instead of the API port
+func (s *Server) separateMetrics() bool {
+	return s.config.MetricsPort != ""
+}
+
+// MetricsAddr returns the address of the metrics listener, which is the API
+// address unless metricsPort is set
+func (s *Server) MetricsAddr() string {
+	if !s.separateMetrics() {
+		return s.Addr()
+	}
+	host := s.config.MetricsBindAddress
+	if host == "" {
+		host = s.config.BindAddress
+	}
+	return net.JoinHostPort(host, s.config.MetricsPort)
+}
+
+// startMetrics serves /metrics alone on the metrics address, so it can be
+// kept on a private interface away from API clients. Scrapes bypass the API
+// middleware. The address is bound before returning so a port conflict
+// fails startup.
+func (s *Server) startMetrics() error {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", s.metricsHandler())
+
+	s.metricsServer = &http.Server{
+		Addr:         s.MetricsAddr(),
+		Handler:      mux,
+		ReadTimeout:  s.config.ReadTimeout,
+		WriteTimeout: s.config.WriteTimeout,
+		IdleTimeout:  s.config.IdleTimeout,
+	}
+
+	ln, err := net.Listen("tcp", s.metricsServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info().Str("addr", s.metricsServer.Addr).Msg("Metrics listening")
+	go func() {
+		if err := s.metricsServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error().Err(err).Msg("Metrics server failed")
+		}
+	}()
+	return nil
+}
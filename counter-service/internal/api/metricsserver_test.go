@@ -0,0 +1,144 @@
+package api_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yourusername/counter-service/internal/api"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/test"
+)
+
+func TestMetricsBasicAuth(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.MetricsUser = "prometheus"
+	cfg.MetricsPassword = "scrape-secret"
+	handler, _ := newTestAPI(t, cfg)
+
+	get := func(path, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name, user, password string
+	}{
+		{"no credentials", "", ""},
+		{"wrong password", "prometheus", "guess"},
+		{"wrong user", "admin", "scrape-secret"},
+	} {
+		w := get("/metrics", tc.user, tc.password)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: status %d, WWW-Authenticate %q; want 401 with a challenge", tc.name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	if w := get("/metrics", "prometheus", "scrape-secret"); w.Code != http.StatusOK {
+		t.Errorf("Authorized scrape = %d, want 200", w.Code)
+	}
+
+	// The credentials guard /metrics only
+	if w := get("/api/counter", "", ""); w.Code != http.StatusOK {
+		t.Errorf("API request without credentials = %d, want 200", w.Code)
+	}
+}
+
+func TestMetricsOpenWithoutCredentials(t *testing.T) {
+	handler, _ := newTestAPI(t, test.NewTestConfig(t))
+
+	if w := test.PerformRequest(t, http.MethodGet, "/metrics", nil, handler); w.Code != http.StatusOK {
+		t.Errorf("Scrape without configured credentials = %d, want 200", w.Code)
+	}
+}
+
+func TestMetricsAddr(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{"shared", config.Config{BindAddress: "0.0.0.0", Port: "8080"}, "0.0.0.0:8080"},
+		{"own port", config.Config{BindAddress: "0.0.0.0", Port: "8080", MetricsPort: "9090"}, "0.0.0.0:9090"},
+		{"own interface", config.Config{BindAddress: "0.0.0.0", Port: "8080", MetricsPort: "9090", MetricsBindAddress: "10.0.0.5"}, "10.0.0.5:9090"},
+	} {
+		server := api.NewServer(&tc.cfg, test.NewTestLogger(), nil, nil)
+		if got := server.MetricsAddr(); got != tc.want {
+			t.Errorf("%s: MetricsAddr() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// freePort returns a local TCP port that was free a moment ago
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+func TestMetricsOnSeparatePort(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.BindAddress = "127.0.0.1"
+	cfg.Port = freePort(t)
+	cfg.MetricsPort = freePort(t)
+	logger := test.NewTestLogger()
+	metrics := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), logger, metrics)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+
+	server := api.NewServer(cfg, logger, service, metrics)
+	started := make(chan error, 1)
+	go func() { started <- server.Start() }()
+	defer server.Shutdown()
+
+	get := func(addr, path string) int {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, err := http.Get("http://" + addr + path)
+			if err == nil {
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			select {
+			case err := <-started:
+				t.Fatalf("Server stopped: %v", err)
+			default:
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET %s%s: %v", addr, path, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if code := get(server.MetricsAddr(), "/metrics"); code != http.StatusOK {
+		t.Errorf("/metrics on the metrics port = %d, want 200", code)
+	}
+	if code := get(server.Addr(), "/metrics"); code != http.StatusNotFound {
+		t.Errorf("/metrics on the API port = %d, want 404", code)
+	}
+	if code := get(server.Addr(), "/api/counter"); code != http.StatusOK {
+		t.Errorf("/api/counter on the API port = %d, want 200", code)
+	}
+	if code := get(server.MetricsAddr(), "/api/counter"); code != http.StatusNotFound {
+		t.Errorf("/api/counter on the metrics port = %d, want 404", code)
+	}
+}
@@ -3,34 +3,86 @@ package api
 import (
 	"context"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/logging"
 	"golang.org/x/time/rate"
 )
 
+// middleware is a named layer of the handler stack
+type middleware struct {
+	name string
+	wrap func(http.Handler) http.Handler
+}
+
+// chain wraps h in the middleware in stack, so that stack[0] is outermost
+// and sees each request first
+func chain(h http.Handler, stack []middleware) http.Handler {
+	for i := len(stack) - 1; i >= 0; i-- {
+		h = stack[i].wrap(h)
+	}
+	return h
+}
+
 // contextKey is a type for context keys
 type contextKey string
 
 // requestIDKey is the context key for request ID
 const requestIDKey = contextKey("requestID")
 
+// requestIDHeader is the header used to propagate request IDs
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of an accepted inbound request ID
+const maxRequestIDLength = 128
+
 // requestCounter is used to generate unique request IDs
 var requestCounter int64
 
-// responseWriter wraps http.ResponseWriter to capture the status code
+// validRequestID reports whether an inbound request ID is safe to reuse
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// responseWriter wraps http.ResponseWriter to capture the status code, the
+// number of body bytes written and the handling duration measured by
+// metricsMiddleware
 type responseWriter struct {
 	http.ResponseWriter
-	status int
+	status   int
+	bytes    int
+	duration time.Duration
+
+	// unmatched is set by the not-found handler so metrics group unknown
+	// paths under one label
+	unmatched bool
 }
 
+// unmatchedEndpoint is the metrics endpoint label for paths with no route
+const unmatchedEndpoint = "unmatched"
+
 // newResponseWriter creates a new responseWriter
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, http.StatusOK}
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
 }
 
 // WriteHeader captures the status code
@@ -39,17 +91,43 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// requestLogMiddleware logs HTTP requests
-func requestLogMiddleware(logger *zerolog.Logger, metrics *metrics.Metrics) func(http.Handler) http.Handler {
+// Write counts the bytes written to the response body
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// requestLogMiddleware logs HTTP requests. With sampleRate N > 1 only one in
+// N successful requests is logged; non-2xx responses and requests slower than
+// slowThreshold are always logged. Sensitive query parameters are masked by rd.
+func requestLogMiddleware(logger *zerolog.Logger, sampleRate int, slowThreshold time.Duration, rd *redactor) func(http.Handler) http.Handler {
+	sampledLogger := logger
+	if sampleRate > 1 {
+		sampled := logger.Sample(&zerolog.BasicSampler{N: uint32(sampleRate)})
+		sampledLogger = &sampled
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
-			// Generate request ID
-			requestID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddInt64(&requestCounter, 1))
+			// Prefer the caller's request ID, otherwise generate one
+			requestID := r.Header.Get(requestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddInt64(&requestCounter, 1))
+			}
+			w.Header().Set(requestIDHeader, requestID)
 
-			// Add request ID to context
+			// Add request ID and a logger tagged with it to the context, so
+			// service logs can be tied back to the request
 			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+			ctx = logging.LoggerWithRequestID(logger, requestID).WithContext(ctx)
 			r = r.WithContext(ctx)
 
 			// Wrap response writer to capture status code
@@ -58,31 +136,46 @@ func requestLogMiddleware(logger *zerolog.Logger, metrics *metrics.Metrics) func
 			// Process request
 			next.ServeHTTP(rw, r)
 
-			// Calculate duration
-			duration := time.Since(start)
-			durationSeconds := float64(duration) / float64(time.Second)
+			// Reuse the duration measured by metricsMiddleware when available
+			duration := rw.duration
+			if duration == 0 {
+				duration = time.Since(start)
+			}
 
-			// Update metrics
-			metrics.RequestDuration.WithLabelValues(r.URL.Path).Observe(durationSeconds)
-			metrics.RequestsTotal.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", rw.status)).Inc()
+			// Errors and slow requests bypass sampling
+			requestLogger := sampledLogger
+			if rw.status < 200 || rw.status >= 300 || (slowThreshold > 0 && duration >= slowThreshold) {
+				requestLogger = logger
+			}
 
 			// Log request
-			logger.Info().
+			event := requestLogger.Info().
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
 				Str("remote", r.RemoteAddr).
 				Int("status", rw.status).
 				Str("requestID", requestID).
-				Float64("duration_ms", float64(duration.Microseconds())/1000.0).
-				Msg("Request processed")
+				Float64("duration_ms", float64(duration.Microseconds())/1000.0)
+			if query := rd.query(r.URL); query != "" {
+				event = event.Str("query", query)
+			}
+			event.Msg("Request processed")
 		})
 	}
 }
 
-// rateLimitMiddleware implements rate limiting
-func rateLimitMiddleware(logger *zerolog.Logger, limiter *rate.Limiter) func(http.Handler) http.Handler {
+// rateLimitMiddleware implements rate limiting, replying in the envelope
+// format when envelope is set. Requests from the exempt networks bypass the
+// limiter and do not use up its tokens. Rejections are counted by route,
+// looked up in mux, with paths it has no route for grouped as unmatched.
+func rateLimitMiddleware(logger *zerolog.Logger, limiter *rate.Limiter, exempt []*net.IPNet, mux *http.ServeMux, envelope bool, metrics *metrics.Metrics) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(exempt) > 0 && remoteIn(r, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Check if rate limit exceeded
 			if !limiter.Allow() {
 				logger.Warn().
@@ -91,7 +184,16 @@ func rateLimitMiddleware(logger *zerolog.Logger, limiter *rate.Limiter) func(htt
 					Str("path", r.URL.Path).
 					Msg("Rate limit exceeded")
 
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				// Scanners probing random paths would otherwise create a
+				// series per path
+				endpoint := r.URL.Path
+				if _, pattern := mux.Handler(r); pattern == "" {
+					endpoint = unmatchedEndpoint
+				}
+
+				metrics.RateLimitRejections.WithLabelValues(endpoint).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limiter)))
+				writeError(w, r, envelope, http.StatusTooManyRequests, "Too many requests", "RATE_LIMITED")
 				return
 			}
 
@@ -100,8 +202,132 @@ func rateLimitMiddleware(logger *zerolog.Logger, limiter *rate.Limiter) func(htt
 	}
 }
 
-// recoverMiddleware recovers from panics
-func recoverMiddleware(logger *zerolog.Logger) func(http.Handler) http.Handler {
+// remoteIn reports whether the address the request came from, ignoring any
+// forwarding headers a client could set, is in one of networks
+func remoteIn(r *http.Request, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfterSeconds estimates how long a client should wait before the
+// limiter admits another request, rounded up to whole seconds
+func retryAfterSeconds(limiter *rate.Limiter) int {
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return 1
+	}
+	delay := reservation.Delay()
+	reservation.Cancel()
+
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// bodyLimitMiddleware rejects request bodies larger than maxBytes, replying
+// in the envelope format when envelope is set
+func bodyLimitMiddleware(maxBytes int64, envelope bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxBytes <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Reject early when the declared length is already too large
+			if r.ContentLength > maxBytes {
+				writeError(w, r, envelope, http.StatusRequestEntityTooLarge, "Request body too large", "BODY_TOO_LARGE")
+				return
+			}
+
+			// Cap bodies of unknown length; reads past the limit fail with *http.MaxBytesError
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutMiddleware bounds how long a handler may run by attaching a
+// deadline to the request context; handlers report expiry as 503 TIMEOUT
+func timeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// securityHeadersMiddleware sets common hardening headers on every response.
+// The server does not terminate TLS itself, so a request also counts as TLS
+// for HSTS when it comes from one of the trusted proxies with
+// X-Forwarded-Proto set to https.
+func securityHeadersMiddleware(strictTransportSecurity string, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "no-referrer")
+
+			// HSTS is only meaningful over TLS
+			if strictTransportSecurity != "" && overTLS(r, trustedProxies) {
+				h.Set("Strict-Transport-Security", strictTransportSecurity)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// overTLS reports whether the request reached the server over TLS, or
+// reached a trusted proxy over TLS according to its X-Forwarded-Proto.
+// Clients can set the header too, so it is ignored from anyone else.
+func overTLS(r *http.Request, trustedProxies []*net.IPNet) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return len(trustedProxies) > 0 &&
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") &&
+		remoteIn(r, trustedProxies)
+}
+
+// writeError sends an error response from middleware, where no Handler is
+// available, in the same format as the handlers
+func writeError(w http.ResponseWriter, r *http.Request, envelope bool, statusCode int, message string, errorCode string) {
+	requestID, _ := r.Context().Value(requestIDKey).(string)
+
+	writeResponse(w, statusCode, HTTPResponse{
+		Success:   false,
+		Error:     message,
+		ErrorCode: errorCode,
+		RequestID: requestID,
+	}, envelope)
+}
+
+// recoverMiddleware recovers from panics, logging the request with sensitive
+// headers and query parameters masked by rd
+func recoverMiddleware(logger *zerolog.Logger, rd *redactor) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
@@ -109,6 +335,8 @@ func recoverMiddleware(logger *zerolog.Logger) func(http.Handler) http.Handler {
 					logger.Error().
 						Str("panic", fmt.Sprintf("%v", err)).
 						Str("path", r.URL.Path).
+						Str("query", rd.query(r.URL)).
+						Dict("headers", rd.headerDict(r.Header)).
 						Msg("Recovered from panic")
 
 					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -120,15 +348,46 @@ func recoverMiddleware(logger *zerolog.Logger) func(http.Handler) http.Handler {
 	}
 }
 
-// metricsMiddleware captures basic metrics for each request
-func metricsMiddleware(metrics *metrics.Metrics) func(http.Handler) http.Handler {
+// inFlightMiddleware tracks the number of requests being served concurrently.
+// It sits inside recoverMiddleware and decrements via defer, so the gauge
+// stays accurate when a handler panics.
+func inFlightMiddleware(metrics *metrics.Metrics) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Start the timer
-			timer := prometheus.NewTimer(metrics.RequestDuration.WithLabelValues(r.URL.Path))
-			defer timer.ObserveDuration()
+			metrics.InFlightRequests.Inc()
+			defer metrics.InFlightRequests.Dec()
 
 			next.ServeHTTP(w, r)
 		})
 	}
-}
\ No newline at end of file
+}
+
+// metricsMiddleware captures basic metrics for each request. It is the only
+// place request duration is observed; the measured duration is stored on the
+// shared responseWriter so requestLogMiddleware can log it.
+func metricsMiddleware(metrics *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Reuse the writer wrapped by requestLogMiddleware if present
+			rw, ok := w.(*responseWriter)
+			if !ok {
+				rw = newResponseWriter(w)
+			}
+
+			start := time.Now()
+			next.ServeHTTP(rw, r)
+			rw.duration = time.Since(start)
+
+			// Scanners probing random paths would otherwise create a
+			// series per path
+			endpoint := r.URL.Path
+			if rw.unmatched {
+				endpoint = unmatchedEndpoint
+			}
+
+			metrics.RequestDuration.WithLabelValues(endpoint).Observe(rw.duration.Seconds())
+			metrics.RequestsTotal.WithLabelValues(r.Method, endpoint, strconv.Itoa(rw.status)).Inc()
+			metrics.ResponseSize.WithLabelValues(endpoint).Observe(float64(rw.bytes))
+		})
+	}
+}
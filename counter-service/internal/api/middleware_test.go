@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// okHandler answers every request with 200 and no body
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRateLimitMiddlewareRejectsWithEnvelope(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/counter", okHandler)
+
+	// One request per burst, refilled far slower than the test runs
+	limiter := rate.NewLimiter(rate.Limit(0.001), 1)
+	logger := zerolog.Nop()
+	handler := rateLimitMiddleware(&logger, limiter, nil, mux, true, m)(mux)
+
+	paths := []string{"/api/counter", "/api/counter", "/wp-login.php", "/.env"}
+	for i, path := range paths {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if i == 0 {
+			if w.Code != http.StatusOK {
+				t.Fatalf("First request status = %d, want 200", w.Code)
+			}
+			continue
+		}
+
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("%s status = %d, want 429", path, w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After", path)
+		}
+		var response HTTPResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: body %q is not the JSON envelope: %v", path, w.Body.String(), err)
+		}
+		if response.Success || response.ErrorCode != "RATE_LIMITED" {
+			t.Errorf("%s: response = %+v, want error code RATE_LIMITED", path, response)
+		}
+	}
+
+	for endpoint, want := range map[string]float64{"/api/counter": 1, unmatchedEndpoint: 2} {
+		var d dto.Metric
+		if err := m.RateLimitRejections.WithLabelValues(endpoint).Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.GetCounter().GetValue(); got != want {
+			t.Errorf("Rejections for %q = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareExemptCIDRs(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/counter", okHandler)
+	var exempt []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "::1/128"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exempt = append(exempt, network)
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(0.001), 1)
+	logger := zerolog.Nop()
+	handler := rateLimitMiddleware(&logger, limiter, exempt, mux, true, m)(mux)
+	serve := func(remote, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/counter", nil)
+		req.RemoteAddr = remote
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Exempt clients are never limited and use none of the burst
+	for i := 0; i < 5; i++ {
+		for _, remote := range []string{"10.1.2.3:5555", "[::1]:5555"} {
+			if code := serve(remote, ""); code != http.StatusOK {
+				t.Fatalf("Request %d from exempt %s = %d, want 200", i+1, remote, code)
+			}
+		}
+	}
+
+	// Others get the burst and are then limited, whatever they claim
+	if code := serve("192.0.2.1:5555", ""); code != http.StatusOK {
+		t.Errorf("First request from a non-exempt client = %d, want 200", code)
+	}
+	if code := serve("192.0.2.1:5555", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Second request from a non-exempt client = %d, want 429", code)
+	}
+	if code := serve("192.0.2.1:5555", "10.1.2.3"); code != http.StatusTooManyRequests {
+		t.Errorf("Request forwarded for an exempt address = %d, want 429", code)
+	}
+}
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	const hsts = "max-age=31536000; includeSubDomains"
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	handler := securityHeadersMiddleware(hsts, []*net.IPNet{proxies})(okHandler)
+
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		tls       bool
+		forwarded string
+		wantHSTS  bool
+	}{
+		{"plain HTTP", "192.0.2.1:1234", false, "", false},
+		{"direct TLS", "192.0.2.1:1234", true, "", true},
+		{"TLS at a trusted proxy", "10.1.2.3:1234", false, "https", true},
+		{"HTTP at a trusted proxy", "10.1.2.3:1234", false, "http", false},
+		{"header from a client", "192.0.2.1:1234", false, "https", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/counter", nil)
+			req.RemoteAddr = tc.remote
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tc.forwarded)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			for header, want := range map[string]string{
+				"X-Content-Type-Options": "nosniff",
+				"X-Frame-Options":        "DENY",
+				"Referrer-Policy":        "no-referrer",
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+
+			want := ""
+			if tc.wantHSTS {
+				want = hsts
+			}
+			if got := w.Header().Get("Strict-Transport-Security"); got != want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// histogramCount returns how many observations a histogram series has
+func histogramCount(t *testing.T, vec *prometheus.HistogramVec, labels ...string) uint64 {
+	t.Helper()
+
+	var d dto.Metric
+	if err := vec.WithLabelValues(labels...).(prometheus.Histogram).Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	return d.GetHistogram().GetSampleCount()
+}
+
+func TestRequestDurationObservedOnce(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	logger := zerolog.Nop()
+
+	// Both layers measure the request; only metricsMiddleware may observe it
+	handler := requestLogMiddleware(&logger, 1, 0, newRedactor(nil, nil))(metricsMiddleware(m)(okHandler))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+
+	if got := histogramCount(t, m.RequestDuration, "/api/counter"); got != 1 {
+		t.Errorf("Request duration count = %d after one request, want 1", got)
+	}
+}
+
+// gaugeValue returns the current value of a gauge
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+
+	var d dto.Metric
+	if err := g.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	return d.GetGauge().GetValue()
+}
+
+func TestInFlightMiddleware(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := inFlightMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+
+	const concurrent = 3
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+		}()
+	}
+	for i := 0; i < concurrent; i++ {
+		<-entered
+	}
+
+	if got := gaugeValue(t, m.InFlightRequests); got != concurrent {
+		t.Errorf("In-flight requests = %v with %d blocked handlers, want %d", got, concurrent, concurrent)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := gaugeValue(t, m.InFlightRequests); got != 0 {
+		t.Errorf("In-flight requests = %v after they finished, want 0", got)
+	}
+}
+
+func TestInFlightMiddlewarePanic(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	logger := zerolog.Nop()
+	handler := recoverMiddleware(&logger, newRedactor(nil, nil))(inFlightMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status = %d, want 500", w.Code)
+	}
+	if got := gaugeValue(t, m.InFlightRequests); got != 0 {
+		t.Errorf("In-flight requests = %v after a panic, want 0", got)
+	}
+}
+
+func TestResponseSizeObserved(t *testing.T) {
+	m := metrics.NewMetrics(&config.Config{})
+	body := `{"visits":12345}`
+	handler := metricsMiddleware(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body[:5]))
+		w.Write([]byte(body[5:]))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+
+	var d dto.Metric
+	if err := m.ResponseSize.WithLabelValues("/api/counter").(prometheus.Histogram).Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetHistogram().GetSampleCount(); got != 1 {
+		t.Fatalf("Response size count = %d, want 1", got)
+	}
+	if got := d.GetHistogram().GetSampleSum(); got != float64(len(body)) {
+		t.Errorf("Observed size = %v, want the body length %d", got, len(body))
+	}
+}
+
+func TestRequestLogSampling(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	status := http.StatusOK
+	handler := requestLogMiddleware(&logger, 10, 0, newRedactor(nil, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	serve := func(n int) {
+		for i := 0; i < n; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+		}
+	}
+
+	serve(100)
+	if got := strings.Count(out.String(), `"status":200`); got != 10 {
+		t.Errorf("Logged %d of 100 successful requests at sample rate 10, want 10", got)
+	}
+
+	out.Reset()
+	status = http.StatusInternalServerError
+	serve(25)
+	if got := strings.Count(out.String(), `"status":500`); got != 25 {
+		t.Errorf("Logged %d of 25 failed requests, want all of them", got)
+	}
+}
+
+func TestRequestLogSlowRequestsBypassSampling(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	handler := requestLogMiddleware(&logger, 1000, time.Millisecond, newRedactor(nil, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+	}))
+
+	for i := 0; i < 5; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter", nil))
+	}
+	if got := strings.Count(out.String(), "Request processed"); got != 5 {
+		t.Errorf("Logged %d of 5 slow requests, want all of them", got)
+	}
+}
+
+// tagMiddleware appends name to the X-Middleware-Order response header as
+// the request passes through
+func tagMiddleware(name string) middleware {
+	return middleware{name: name, wrap: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware-Order", name)
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
+
+func TestChainOrder(t *testing.T) {
+	stack := []middleware{tagMiddleware("first"), tagMiddleware("second"), tagMiddleware("third")}
+	handler := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Middleware-Order", "handler")
+	}), stack)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	got := strings.Join(w.Header().Values("X-Middleware-Order"), ",")
+	if want := "first,second,third,handler"; got != want {
+		t.Errorf("Requests passed through %s, want %s", got, want)
+	}
+}
+
+func TestMiddlewareStackOrder(t *testing.T) {
+	names := func(cfg *config.Config) string {
+		logger := zerolog.Nop()
+		s := NewServer(cfg, &logger, nil, metrics.NewMetrics(&config.Config{}))
+		var names []string
+		for _, m := range s.middlewareStack(http.NewServeMux()) {
+			names = append(names, m.name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	all := &config.Config{EnableTracing: true, EnableCORS: true, EnableGzip: true, EnableSecurityHeaders: true, LogRequestBodies: true, RateLimit: 10, RateBurst: 10}
+	if got, want := names(all), "tracing,cors,gzip,security-headers,recover,request-log,in-flight,metrics,rate-limit,body-limit,body-log"; got != want {
+		t.Errorf("Stack with everything enabled = %s, want %s", got, want)
+	}
+
+	// Optional middleware drop out without reordering the rest
+	if got, want := names(&config.Config{RateLimit: 10, RateBurst: 10}), "recover,request-log,in-flight,metrics,rate-limit,body-limit"; got != want {
+		t.Errorf("Minimal stack = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// route describes an endpoint, used both to register it on the mux and to
+// document it in the OpenAPI spec
+type route struct {
+	path        string
+	method      string
+	summary     string
+	handler     http.Handler
+	contentType string // defaults to application/json with the HTTPResponse envelope
+}
+
+// openAPISpec builds an OpenAPI 3 document describing routes
+func openAPISpec(routes []route) map[string]interface{} {
+	envelope := map[string]interface{}{"$ref": "#/components/schemas/HTTPResponse"}
+
+	paths := make(map[string]interface{}, len(routes))
+	for _, rt := range routes {
+		responses := map[string]interface{}{}
+		if rt.contentType == "" {
+			content := map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": envelope},
+			}
+			responses["200"] = map[string]interface{}{"description": "Success", "content": content}
+			responses["default"] = map[string]interface{}{"description": "Error", "content": content}
+		} else {
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					rt.contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+				},
+			}
+		}
+
+		operations, ok := paths[rt.path].(map[string]interface{})
+		if !ok {
+			operations = map[string]interface{}{}
+			paths[rt.path] = operations
+		}
+		operations[methodKey(rt.method)] = map[string]interface{}{
+			"summary":   rt.summary,
+			"responses": responses,
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Counter Service API",
+			"version": config.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"HTTPResponse": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"success"},
+					"properties": map[string]interface{}{
+						"success":          map[string]interface{}{"type": "boolean"},
+						"data":             map[string]interface{}{"type": "object"},
+						"error":            map[string]interface{}{"type": "string"},
+						"error_code":       map[string]interface{}{"type": "string"},
+						"request_id":       map[string]interface{}{"type": "string"},
+						"response_time_ms": map[string]interface{}{"type": "number"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// methodKey returns the OpenAPI operation key for an HTTP method
+func methodKey(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "post"
+	case http.MethodPut:
+		return "put"
+	case http.MethodDelete:
+		return "delete"
+	case http.MethodPatch:
+		return "patch"
+	default:
+		return "get"
+	}
+}
+
+// openAPIHandler serves the OpenAPI document for routes. The document is
+// rendered once, since the route table does not change after startup.
+func openAPIHandler(routes []route) (http.Handler, error) {
+	spec, err := json.Marshal(openAPISpec(routes))
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}), nil
+}
+
+// swaggerUIPage renders Swagger UI for /openapi.json. The UI assets are
+// loaded from a CDN, so the browser needs internet access.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Counter Service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// docsHandler serves Swagger UI
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,61 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yourusername/counter-service/internal/test"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.EnableDocs = true
+	handler, _ := newTestAPI(t, cfg)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", w.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Spec is not valid JSON: %v", err)
+	}
+	if spec.OpenAPI == "" {
+		t.Error("Spec has no openapi version")
+	}
+
+	// The spec is generated from the route table, so registered routes
+	// appear under their methods
+	for path, method := range map[string]string{
+		"/api/counter/increment": "post",
+		"/api/counter":           "get",
+		"/health":                "get",
+	} {
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("Spec has no %s operation for %s", method, path)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /docs status = %d, want 200", w.Code)
+	}
+}
+
+func TestOpenAPIDisabled(t *testing.T) {
+	handler, _ := newTestAPI(t, test.NewTestConfig(t))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status = %d with docs disabled, want 404", w.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/pprof"
+)
+
+// newProfilingHandler returns a mux serving the net/http/pprof endpoints
+// under /debug/pprof/
+func newProfilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startProfiling serves pprof on its own listener. Keeping it off the main
+// server means profiles bypass the rate limiter and request logging, and
+// long CPU profiles are not cut off by the API write timeout.
+func (s *Server) startProfiling() {
+	s.debugServer = &http.Server{
+		Addr:        s.config.ProfilingAddress,
+		Handler:     newProfilingHandler(),
+		ReadTimeout: s.config.ReadTimeout,
+		IdleTimeout: s.config.IdleTimeout,
+	}
+
+	s.logger.Warn().Str("addr", s.debugServer.Addr).Msg("Profiling endpoints enabled")
+	go func() {
+		if err := s.debugServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error().Err(err).Msg("Profiling server failed")
+		}
+	}()
+}
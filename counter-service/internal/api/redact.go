@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// redactedValue replaces sensitive values in logs
+const redactedValue = "REDACTED"
+
+// redactor masks configured header and query parameter values before they
+// are logged
+type redactor struct {
+	headers map[string]struct{}
+	params  map[string]struct{}
+}
+
+// newRedactor creates a redactor for the given header and query parameter
+// names, both matched case-insensitively
+func newRedactor(headers []string, params []string) *redactor {
+	rd := &redactor{
+		headers: make(map[string]struct{}, len(headers)),
+		params:  make(map[string]struct{}, len(params)),
+	}
+	for _, h := range headers {
+		rd.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, p := range params {
+		rd.params[strings.ToLower(p)] = struct{}{}
+	}
+	return rd
+}
+
+// query returns the URL's query string with sensitive values masked
+func (rd *redactor) query(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		// Unparseable queries may still carry secrets, so hide them entirely
+		return redactedValue
+	}
+
+	for key, vals := range values {
+		if _, ok := rd.params[strings.ToLower(key)]; ok {
+			for i := range vals {
+				vals[i] = redactedValue
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// headerDict returns the request headers as a log dictionary with sensitive
+// values masked
+func (rd *redactor) headerDict(h http.Header) *zerolog.Event {
+	dict := zerolog.Dict()
+	for key, vals := range h {
+		if _, ok := rd.headers[http.CanonicalHeaderKey(key)]; ok {
+			dict.Str(key, redactedValue)
+			continue
+		}
+		dict.Str(key, strings.Join(vals, ", "))
+	}
+	return dict
+}
+
+// body returns a JSON body with the values of fields named like sensitive
+// query parameters masked, at any depth. Bodies that are not valid JSON may
+// still carry secrets, so they are hidden entirely.
+func (rd *redactor) body(b []byte) string {
+	// Keep numbers exact rather than converting them to float64
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return redactedValue
+	}
+	masked, err := json.Marshal(rd.mask(v))
+	if err != nil {
+		return redactedValue
+	}
+	return string(masked)
+}
+
+// mask replaces sensitive object fields in a decoded JSON value
+func (rd *redactor) mask(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if _, ok := rd.params[strings.ToLower(key)]; ok {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = rd.mask(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = rd.mask(val)
+		}
+	}
+	return v
+}
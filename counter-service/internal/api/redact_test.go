@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRequestLogRedactsQueryParams(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	rd := newRedactor(nil, []string{"token", "api_key"})
+	handler := requestLogMiddleware(&logger, 1, 0, rd)(okHandler)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/counter?Token=s3cret&API_KEY=k3y&rate=true", nil))
+
+	logged := out.String()
+	for _, secret := range []string{"s3cret", "k3y"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Log contains secret %q: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "Token=REDACTED") || !strings.Contains(logged, "rate=true") {
+		t.Errorf("Log does not show the masked query: %s", logged)
+	}
+}
+
+func TestRecoverLogRedactsHeaders(t *testing.T) {
+	var out bytes.Buffer
+	logger := zerolog.New(&out)
+	rd := newRedactor([]string{"authorization"}, []string{"token"})
+	handler := recoverMiddleware(&logger, rd)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/counter?token=s3cret", nil)
+	req.Header.Set("Authorization", "Bearer hunter2")
+	req.Header.Set("User-Agent", "tests")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logged := out.String()
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, "s3cret") {
+		t.Errorf("Panic log contains a secret: %s", logged)
+	}
+	if !strings.Contains(logged, `"Authorization":"REDACTED"`) || !strings.Contains(logged, `"User-Agent":"tests"`) {
+		t.Errorf("Panic log does not show the masked headers: %s", logged)
+	}
+}
+
+func TestRedactorUnparseableQuery(t *testing.T) {
+	rd := newRedactor(nil, []string{"token"})
+	req := httptest.NewRequest(http.MethodGet, "/api/counter", nil)
+	req.URL.RawQuery = "token=%zz"
+
+	if got := rd.query(req.URL); got != redactedValue {
+		t.Errorf("query = %q for an unparseable query, want it hidden", got)
+	}
+}
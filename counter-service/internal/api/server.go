@@ -3,18 +3,25 @@ package api
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/rs/cors"
 	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/counter"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"golang.org/x/time/rate"
 )
 
+// forceCloseGrace is how long Shutdown waits past the shutdown timeout for
+// the graceful shutdown to return before closing connections regardless
+const forceCloseGrace = time.Second
+
 // Server represents the HTTP server
 type Server struct {
 	config         *config.Config
@@ -22,6 +29,8 @@ type Server struct {
 	counterService *counter.Service
 	metrics        *metrics.Metrics
 	server         *http.Server
+	debugServer    *http.Server
+	metricsServer  *http.Server
 }
 
 // NewServer creates a new server instance
@@ -40,88 +49,274 @@ func (s *Server) setupRoutes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Create handler
-	handler := NewHandler(s.counterService, s.logger)
+	handler := NewHandler(s.counterService, s.metrics, s.logger, s.config.EnvelopeResponses, s.config.ResponseSigningKey)
 
-	// Register API routes
-	mux.HandleFunc("/api/counter/increment", handler.IncrementCounter)
-	mux.HandleFunc("/api/counter", handler.GetCounter)
-	mux.HandleFunc("/health", handler.HealthCheck)
+	// Bound how long API handlers may run
+	withTimeout := timeoutMiddleware(s.config.HandlerTimeout)
 
-	// Register metrics endpoint
-	if s.config.EnableMetrics {
-		mux.Handle("/metrics", promhttp.Handler())
+	// API routes, also the source of the OpenAPI spec
+	routes := []route{
+		{path: "/api/counter/increment", method: http.MethodPost, summary: "Increment the counter and return the new value", handler: withTimeout(http.HandlerFunc(handler.IncrementCounter))},
+		{path: "/api/counter/compare-and-increment", method: http.MethodPost, summary: "Increment the counter only if it has the expected value", handler: withTimeout(http.HandlerFunc(handler.CompareAndIncrement))},
+		{path: "/api/counter", method: http.MethodGet, summary: "Get the current counter value", handler: withTimeout(http.HandlerFunc(handler.GetCounter))},
+		{path: "/api/counter/verify", method: http.MethodGet, summary: "Check the on-disk counter file against its CRC", handler: withTimeout(http.HandlerFunc(handler.VerifyCounter))},
+		{path: "/api/counter/export", method: http.MethodGet, summary: "Export the counter state", handler: withTimeout(http.HandlerFunc(handler.ExportCounter))},
+		{path: "/api/stats", method: http.MethodGet, summary: "Summarize service metrics as JSON", handler: withTimeout(http.HandlerFunc(handler.Stats))},
+		{path: "/health", method: http.MethodGet, summary: "Report service health", handler: http.HandlerFunc(handler.HealthCheck)},
+		{path: "/ready", method: http.MethodGet, summary: "Report whether the service is ready for traffic", handler: http.HandlerFunc(handler.ReadinessCheck)},
+		{path: "/warmup", method: http.MethodGet, summary: "Exercise the persistence path before receiving traffic", handler: withTimeout(http.HandlerFunc(handler.Warmup))},
 	}
 
-	// Apply middleware stack
-	var middleware http.Handler = mux
+	// State import replaces the counter, so it is only exposed when enabled
+	if s.config.EnableStateImport {
+		routes = append(routes, route{path: "/api/counter/import", method: http.MethodPost, summary: "Replace the counter with exported state", handler: withTimeout(http.HandlerFunc(handler.ImportCounter))})
+	}
 
-	// Rate limiting
-	limiter := rate.NewLimiter(rate.Limit(s.config.RateLimit), s.config.RateBurst)
-	middleware = rateLimitMiddleware(s.logger, limiter)(middleware)
+	// Metrics endpoint, unless it has a listener of its own
+	if s.config.EnableMetrics && !s.separateMetrics() {
+		routes = append(routes, route{
+			path:        "/metrics",
+			method:      http.MethodGet,
+			summary:     "Prometheus metrics",
+			handler:     s.metricsHandler(),
+			contentType: "text/plain",
+		})
+	}
+
+	// Register routes with their method, so the mux rejects other methods
+	for _, rt := range routes {
+		mux.Handle(rt.method+" "+rt.path, rt.handler)
+	}
+
+	// Kept out of the OpenAPI spec
+	mux.HandleFunc("GET /favicon.ico", handler.Favicon)
+
+	// Register API documentation
+	if s.config.EnableDocs {
+		spec, err := openAPIHandler(routes)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Failed to render OpenAPI spec, API docs disabled")
+		} else {
+			mux.Handle("GET /openapi.json", spec)
+			mux.HandleFunc("GET /docs", docsHandler)
+		}
+	}
+
+	stack := s.middlewareStack(mux)
+	names := make([]string, len(stack))
+	for i, m := range stack {
+		names[i] = m.name
+	}
+	s.logger.Debug().Strs("middleware", names).Msg("Middleware stack, outermost first")
+
+	return chain(routeErrors(mux, handler), stack)
+}
 
-	// Metrics middleware
-	middleware = metricsMiddleware(s.metrics)(middleware)
+// routeErrors serves requests through mux, but answers unknown paths and
+// methods a route does not accept in the API's JSON error format instead of
+// the mux's plain text
+func routeErrors(mux *http.ServeMux, handler *Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
 
-	// Request logging
-	middleware = requestLogMiddleware(s.logger, s.metrics)(middleware)
+		// The mux's own error handler tells the two apart and knows which
+		// methods the path allows
+		probe := &probeWriter{header: http.Header{}}
+		h.ServeHTTP(probe, r)
+		if probe.status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", probe.header.Get("Allow"))
+			handler.MethodNotAllowed(w, r)
+			return
+		}
+		handler.NotFound(w, r)
+	})
+}
+
+// probeWriter records the status and headers of a response, discarding the
+// body
+type probeWriter struct {
+	header http.Header
+	status int
+}
 
-	// Panic recovery
-	middleware = recoverMiddleware(s.logger)(middleware)
+func (p *probeWriter) Header() http.Header         { return p.header }
+func (p *probeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (p *probeWriter) WriteHeader(status int)      { p.status = status }
 
-	// CORS if enabled
+// middlewareStack returns the enabled middleware, outermost first. A request
+// passes through them in this order on its way to the routes:
+//
+//  1. tracing: spans cover the full stack and join any incoming trace
+//  2. cors: preflight requests are answered before anything else runs
+//  3. gzip: compresses everything written below it, including errors
+//  4. security-headers: set on every response, including errors
+//  5. recover: turns panics below it into 500 responses
+//  6. request-log: logs every request with its final status
+//  7. in-flight: counts concurrent requests
+//  8. metrics: records request counts and durations
+//  9. rate-limit: rejects requests over the limit, except from exempt networks
+//  10. body-limit: caps request body size for the handlers
+//  11. body-log: with logRequestBodies, logs the bodies the handlers see
+//
+// New middleware should be added at the position where it must see the
+// request, not appended to the end. mux is the router the stack wraps.
+func (s *Server) middlewareStack(mux *http.ServeMux) []middleware {
+	// Masks secrets in logged headers and query strings
+	rd := newRedactor(s.config.RedactHeaders, s.config.RedactQueryParams)
+	limiter := rate.NewLimiter(rate.Limit(s.config.RateLimit), s.config.RateBurst)
+
+	var stack []middleware
+	if s.config.EnableTracing {
+		stack = append(stack, middleware{name: "tracing", wrap: func(next http.Handler) http.Handler {
+			return otelhttp.NewHandler(next, tracing.ServiceName)
+		}})
+	}
 	if s.config.EnableCORS {
-		corsMiddleware := cors.New(cors.Options{
-			AllowedOrigins:   s.config.AllowedOrigins,
-			AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-			AllowedHeaders:   []string{"Content-Type", "Authorization"},
-			AllowCredentials: true,
-			MaxAge:           300,
-		})
-		middleware = corsMiddleware.Handler(middleware)
+		stack = append(stack, middleware{name: "cors", wrap: corsMiddleware(s.config, s.logger)})
+	}
+	if s.config.EnableGzip {
+		stack = append(stack, middleware{name: "gzip", wrap: gzipMiddleware(s.config.GzipMinBytes)})
+	}
+	if s.config.EnableSecurityHeaders {
+		stack = append(stack, middleware{name: "security-headers", wrap: securityHeadersMiddleware(s.config.StrictTransportSecurity, s.config.TrustedProxyCIDRs)})
 	}
 
-	return middleware
+	stack = append(stack,
+		middleware{name: "recover", wrap: recoverMiddleware(s.logger, rd)},
+		middleware{name: "request-log", wrap: requestLogMiddleware(s.logger, s.config.LogSampleRate, s.config.SlowRequestThreshold, rd)},
+		middleware{name: "in-flight", wrap: inFlightMiddleware(s.metrics)},
+		middleware{name: "metrics", wrap: metricsMiddleware(s.metrics)},
+		middleware{name: "rate-limit", wrap: rateLimitMiddleware(s.logger, limiter, s.config.RateLimitExemptCIDRs, mux, s.config.EnvelopeResponses, s.metrics)},
+		middleware{name: "body-limit", wrap: bodyLimitMiddleware(s.config.MaxBodyBytes, s.config.EnvelopeResponses)},
+	)
+	if s.config.LogRequestBodies {
+		stack = append(stack, middleware{name: "body-log", wrap: bodyLogMiddleware(s.logger, rd)})
+	}
+	return stack
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.config.BindAddress, s.config.Port)
+}
+
+// Handler returns the routes and middleware the server serves on its API
+// address, for tests that serve them with httptest. Each call builds a new
+// handler with its own rate limiter.
+func (s *Server) Handler() http.Handler {
+	handler := s.setupRoutes()
+
+	// Serve HTTP/2 without TLS to clients that ask for it, passing
+	// HTTP/1.1 requests through unchanged
+	if s.config.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: s.config.IdleTimeout})
+	}
+	return handler
 }
 
 // Start begins listening for HTTP requests
 func (s *Server) Start() error {
 	// Create HTTP server
 	s.server = &http.Server{
-		Addr:         ":" + s.config.Port,
-		Handler:      s.setupRoutes(),
+		Addr:         s.Addr(),
+		Handler:      s.Handler(),
 		ReadTimeout:  s.config.ReadTimeout,
 		WriteTimeout: s.config.WriteTimeout,
 		IdleTimeout:  s.config.IdleTimeout,
 	}
 
+	// Start the profiling listener
+	if s.config.EnableProfiling {
+		s.startProfiling()
+	}
+
+	// Start the metrics listener
+	if s.config.EnableMetrics && s.separateMetrics() {
+		if err := s.startMetrics(); err != nil {
+			return err
+		}
+	}
+
+	// Listen separately from Serve so the listener can be wrapped
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Shed connections beyond the limit
+	if s.config.MaxConnections > 0 {
+		ln = newLimitListener(ln, s.config.MaxConnections, s.metrics.ConnectionsRejected)
+	}
+
 	// Start the server
-	s.logger.Info().Str("port", s.config.Port).Msg("Server listening")
-	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	s.logger.Info().Str("addr", s.server.Addr).Msg("Server listening")
+	if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
 	return nil
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. Connections still open when
+// the shutdown timeout expires are closed forcibly.
 func (s *Server) Shutdown() error {
 	if s.server == nil {
 		return nil
 	}
 
+	// Create a context with timeout for the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
 	// Persist counter state before shutdown
-	if err := s.counterService.Persist(); err != nil {
+	if err := s.counterService.Persist(ctx); err != nil {
 		s.logger.Error().Err(err).Msg("Error persisting counter during shutdown")
 	}
 
-	// Create a context with timeout for the shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
-	defer cancel()
+	// Stop the profiling listener
+	if s.debugServer != nil {
+		if err := s.debugServer.Shutdown(ctx); err != nil {
+			s.logger.Error().Err(err).Msg("Error shutting down profiling server")
+		}
+	}
 
-	// Attempt graceful shutdown
-	if err := s.server.Shutdown(ctx); err != nil {
-		return err
+	// Stop the metrics listener
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(ctx); err != nil {
+			s.logger.Error().Err(err).Msg("Error shutting down metrics server")
+		}
 	}
 
-	return nil
-}
\ No newline at end of file
+	// Attempt graceful shutdown, escalating to closing the remaining
+	// connections so the process exits even if handlers hang
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Shutdown(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Shutdown normally returns as soon as ctx expires; allow it a
+		// moment before giving up on it
+		select {
+		case err = <-done:
+		case <-time.After(forceCloseGrace):
+			err = ctx.Err()
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		s.logger.Warn().Dur("timeout", s.config.ShutdownTimeout).Msg("Graceful shutdown timed out, forcibly closing remaining connections")
+		if closeErr := s.server.Close(); closeErr != nil {
+			s.logger.Error().Err(closeErr).Msg("Error closing server")
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+func TestShutdownForceClosesHungHandlers(t *testing.T) {
+	cfg := &config.Config{StorageBackend: counter.StorageMemory, ShutdownTimeout: 100 * time.Millisecond}
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	service, err := counter.NewServiceWithClock(cfg, clock.Real{}, &logger, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Shutdown()
+
+	// A handler that ignores cancellation and never finishes on its own
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	s := NewServer(cfg, &logger, service, m)
+	s.server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.server.Serve(ln)
+
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+	<-entered
+
+	start := time.Now()
+	err = s.Shutdown()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want the deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > cfg.ShutdownTimeout+forceCloseGrace {
+		t.Errorf("Shutdown took %v with a %v timeout", elapsed, cfg.ShutdownTimeout)
+	}
+
+	// The hung request's connection was closed rather than left open
+	select {
+	case err := <-clientErr:
+		if err == nil {
+			t.Error("Hung request completed, want its connection closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("Hung request's connection is still open after shutdown")
+	}
+}
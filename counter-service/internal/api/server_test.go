@@ -0,0 +1,203 @@
+package api_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/counter-service/internal/api"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/test"
+	"golang.org/x/net/http2"
+)
+
+func TestMetricsScrapeIsGzippedOnce(t *testing.T) {
+	server := test.NewTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Decode by hand rather than let the transport do it
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Body is not gzip: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Failed to gunzip body: %v", err)
+	}
+
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		t.Fatal("Body is still gzip after one gunzip")
+	}
+	if !bytes.HasPrefix(body, []byte("# HELP ")) {
+		t.Errorf("Body does not look like Prometheus text: %.80q", body)
+	}
+}
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	server := test.NewTestServer(t)
+	body := `{"expected": 0, "padding": "` + strings.Repeat("x", 64<<10) + `"}`
+
+	for _, tc := range []struct {
+		name string
+		body io.Reader
+	}{
+		// Rejected by the middleware from Content-Length alone
+		{"declared length", strings.NewReader(body)},
+		// Sent chunked, so the limit applies while the handler reads
+		{"unknown length", io.NopCloser(strings.NewReader(body))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/api/counter/compare-and-increment", "application/json", tc.body)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Fatalf("Status = %d, want 413", resp.StatusCode)
+			}
+			var response api.HTTPResponse
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Body is not the JSON envelope: %v", err)
+			}
+			if response.Success || response.ErrorCode != "BODY_TOO_LARGE" || response.Error == "" || response.RequestID == "" {
+				t.Errorf("Response = %+v, want the error envelope with BODY_TOO_LARGE", response)
+			}
+		})
+	}
+
+	// Neither request reached the counter
+	resp, err := http.Get(server.URL + "/api/counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var response api.HTTPResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if visits := response.Data.(map[string]interface{})["visits"]; visits != float64(0) {
+		t.Errorf("Visits = %v after rejected requests, want 0", visits)
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	server := test.NewTestServer(t)
+
+	for _, tc := range []struct {
+		name    string
+		inbound string
+		reused  bool
+	}{
+		{"present", "gateway-7f3a:01.b_c", true},
+		{"absent", "", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"bad characters", "id with spaces/<script>", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/api/counter", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.inbound != "" {
+				req.Header.Set("X-Request-ID", tc.inbound)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var response api.HTTPResponse
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			echoed := resp.Header.Get("X-Request-ID")
+			if echoed == "" || echoed != response.RequestID {
+				t.Fatalf("Header ID %q and body ID %q, want the same non-empty ID", echoed, response.RequestID)
+			}
+			if reused := echoed == tc.inbound; reused != tc.reused {
+				t.Errorf("Response ID = %q for inbound %q, reused %v, want %v", echoed, tc.inbound, reused, tc.reused)
+			}
+		})
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	for _, tc := range []struct {
+		bindAddress string
+		want        string
+	}{
+		{"", ":8080"},
+		{"127.0.0.1", "127.0.0.1:8080"},
+		{"::1", "[::1]:8080"},
+	} {
+		cfg := &config.Config{BindAddress: tc.bindAddress, Port: "8080"}
+		server := api.NewServer(cfg, test.NewTestLogger(), nil, nil)
+		if got := server.Addr(); got != tc.want {
+			t.Errorf("Addr() with BindAddress %q = %q, want %q", tc.bindAddress, got, tc.want)
+		}
+	}
+}
+
+func TestH2C(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.EnableH2C = true
+	handler, _ := newTestAPI(t, cfg)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// HTTP/2 with prior knowledge over a plain TCP connection
+	h2Client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		proto  string
+	}{
+		{"http2", h2Client, "HTTP/2.0"},
+		{"http1", server.Client(), "HTTP/1.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := tc.client.Post(server.URL+"/api/counter/increment", "application/json", nil)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Status = %d, want 200", resp.StatusCode)
+			}
+			if resp.Proto != tc.proto {
+				t.Errorf("Proto = %q, want %q", resp.Proto, tc.proto)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Headers carrying the signature of increment responses
+const (
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// signaturePrefix names the algorithm in the X-Signature value
+const signaturePrefix = "sha256="
+
+// signResponse returns the X-Signature value for body sent at timestamp:
+// the hex HMAC-SHA256 under key of the decimal Unix timestamp, a period,
+// and the body bytes exactly as sent
+func signResponse(key []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(strconv.AppendInt(nil, timestamp, 10))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendSignedJSONResponse sends response like sendJSONResponse, adding
+// X-Signature and X-Signature-Timestamp when a signing key is configured.
+// The body is encoded up front so the signature can go in the headers.
+func (h *Handler) sendSignedJSONResponse(w http.ResponseWriter, statusCode int, response HTTPResponse) {
+	if len(h.signingKey) == 0 {
+		h.sendJSONResponse(w, statusCode, response)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(responseBody(response, h.envelope)); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to encode response")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	timestamp := h.counterService.Now().Unix()
+	w.Header().Set(signatureTimestampHeader, strconv.FormatInt(timestamp, 10))
+	w.Header().Set(signatureHeader, signResponse(h.signingKey, timestamp, body.Bytes()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(body.Bytes())
+}
@@ -2,123 +2,559 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 )
 
 // Version is the application version
 const Version = "1.0.0"
 
+// GitCommit is the commit the binary was built from, injected at build time:
+//
+//	go build -ldflags "-X github.com/yourusername/counter-service/internal/config.GitCommit=$(git rev-parse --short HEAD)"
+var GitCommit = "unknown"
+
 // Constants for default configuration
 const (
-	defaultPort           = "8090"
-	defaultFilename       = "counter.json"
-	defaultShutdownTimeout = 10 * time.Second
-	defaultReadTimeout     = 5 * time.Second
-	defaultWriteTimeout    = 10 * time.Second
-	defaultIdleTimeout     = 120 * time.Second
-	defaultFilePermissions = 0644
-	defaultSaveRetryAttempts = 3
-	defaultSaveRetryDelay    = 100 * time.Millisecond
-	defaultRateLimit         = 10
-	defaultRateBurst         = 20
-	defaultPersistInterval   = 5 * time.Minute
-	defaultLogLevel          = "info"
-	defaultEnvironment       = "development"
+	defaultPort                    = "8090"
+	defaultFilename                = "counter.json"
+	defaultShutdownTimeout         = 10 * time.Second
+	defaultReadTimeout             = 5 * time.Second
+	defaultWriteTimeout            = 10 * time.Second
+	defaultIdleTimeout             = 120 * time.Second
+	defaultMaxBodyBytes            = 64 << 10
+	defaultHandlerTimeout          = 5 * time.Second
+	defaultGzipMinBytes            = 1024
+	defaultStrictTransportSecurity = "max-age=31536000; includeSubDomains"
+	defaultCORSMaxAge              = 5 * time.Minute
+	defaultFilePermissions         = 0644
+	defaultSaveRetryAttempts       = 3
+	defaultSaveRetryDelay          = 100 * time.Millisecond
+	defaultSaveRetryMaxDelay       = 2 * time.Second
+	defaultLockTimeout             = 5 * time.Second
+	defaultMinFreeDiskBytes        = 1 << 20
+	defaultSerializationFormat     = "json"
+	defaultIntegrityAlgorithm      = "crc32"
+	defaultStorageBackend          = "file"
+	defaultRateLimitPerCPU         = 10
+	defaultPersistInterval         = 5 * time.Minute
+	defaultPersistDebounce         = 250 * time.Millisecond
+	defaultDegradeAfterFailures    = 3
+	defaultDegradedRetryMaxDelay   = time.Hour
+	defaultIdempotencyCacheSize    = 10000
+	defaultIdempotencyTTL          = 10 * time.Minute
+	defaultPushgatewayJob          = "counter-service"
+	defaultMetricSubsystem         = "counter"
+	defaultIncrementRateWindow     = time.Minute
+	defaultLogLevel                = "info"
+	defaultLogMaxSizeMB            = 100
+	defaultLogMaxBackups           = 3
+	defaultLogMaxAgeDays           = 28
+	defaultAsyncLogBufferSize      = 1000
+	defaultLogSampleRate           = 1
+	defaultSlowRequestThreshold    = 500 * time.Millisecond
+	defaultEnvironment             = "development"
+	defaultOTLPEndpoint            = "localhost:4318"
+	defaultProfilingAddress        = "127.0.0.1:6060"
 )
 
 // Config holds application configuration
 type Config struct {
 	// Server settings
 	Port            string
+	BindAddress     string
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
 	ShutdownTimeout time.Duration
+	MaxBodyBytes    int64
+	MaxConnections  int
+	HandlerTimeout  time.Duration
 
 	// File persistence settings
-	Filename          string
-	FilePermissions   os.FileMode
-	SaveRetryAttempts int
-	SaveRetryDelay    time.Duration
-	PersistInterval   time.Duration
+	Filename              string
+	StorageBackend        string
+	FilePermissions       os.FileMode
+	SaveRetryAttempts     int
+	SaveRetryDelay        time.Duration
+	SaveRetryMaxDelay     time.Duration
+	LockTimeout           time.Duration
+	ReadOnlyFallback      bool
+	OwnerLock             bool
+	FsyncOnWrite          bool
+	FsyncDirectory        bool
+	MinFreeDiskBytes      uint64
+	SerializationFormat   string
+	IntegrityAlgorithm    string
+	PrettyPrintFile       bool
+	PersistInterval       time.Duration
+	PersistEvery          int
+	PersistDebounce       time.Duration
+	DegradeAfterFailures  int
+	DegradedRetryMaxDelay time.Duration
+	MaxValue              int64
+	InitialValue          int64
+	IncrementRateWindow   time.Duration
 
 	// Rate limiting
-	RateLimit int
-	RateBurst int
+	RateLimit            int
+	RateBurst            int
+	RateLimitExemptCIDRs []*net.IPNet
+
+	// Idempotency settings
+	IdempotencyCacheSize int
+	IdempotencyTTL       time.Duration
 
 	// Feature flags
-	EnableMetrics bool
-	EnableCORS    bool
+	EnableMetrics         bool
+	EnableCORS            bool
+	EnableGzip            bool
+	EnableSecurityHeaders bool
+	EnableTracing         bool
+	EnableDocs            bool
+	EnableProfiling       bool
+	EnableH2C             bool
+	EnableStateImport     bool
+	EnvelopeResponses     bool
+
+	// Metrics settings
+	RequestDurationBuckets   []float64
+	MetricNamespace          string
+	MetricSubsystem          string
+	OperationDurationBuckets []float64
+	MetricsUser              string
+	MetricsPassword          string
+	MetricsPort              string
+	MetricsBindAddress       string
+	PushgatewayURL           string
+	PushgatewayJob           string
+	PushgatewayInterval      time.Duration
+
+	// Security header settings
+	StrictTransportSecurity string
+	TrustedProxyCIDRs       []*net.IPNet
+
+	// Response signing settings
+	ResponseSigningKey string
+
+	// Compression settings
+	GzipMinBytes int
 
 	// CORS settings
-	AllowedOrigins []string
+	AllowedOrigins       []string
+	CORSAllowCredentials bool
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSMaxAge           time.Duration
 
 	// Logging
-	LogLevel    string
-	Environment string
+	LogLevel             string
+	Environment          string
+	UTCTimestamps        bool
+	LogFile              string
+	LogMaxSizeMB         int
+	LogMaxBackups        int
+	LogMaxAgeDays        int
+	AsyncLogging         bool
+	AsyncLogBufferSize   int
+	LogSampleRate        int
+	SlowRequestThreshold time.Duration
+	LogRequestBodies     bool
+	RedactHeaders        []string
+	RedactQueryParams    []string
+
+	// Tracing settings
+	OTLPEndpoint string
+	OTLPInsecure bool
+
+	// Profiling settings
+	ProfilingAddress string
 }
 
-// Load loads the application configuration
-func Load() (*Config, error) {
+// ConfigFileEnv names the environment variable holding an explicit config
+// file path, used when Load is given no path
+const ConfigFileEnv = "CONFIG_FILE"
+
+// configPaths are the directories searched for a config file
+var configPaths = []string{".", "/etc/counter/"}
+
+// configTypes are the config file formats searched for, in order of
+// precedence. YAML comes first so it wins when several files exist.
+var configTypes = []string{"yaml", "yml", "toml", "json"}
+
+// Load loads the application configuration. When path is empty it falls back
+// to the CONFIG_FILE environment variable, and when that is empty too it
+// searches for config.yaml, config.toml or config.json in the working
+// directory and /etc/counter/. An explicit file that does not exist is an
+// error rather than falling back to the defaults.
+func Load(path string) (*Config, error) {
 	// Set up default configuration
 	viper.SetDefault("port", defaultPort)
+	viper.SetDefault("bindAddress", "")
 	viper.SetDefault("readTimeout", defaultReadTimeout)
 	viper.SetDefault("writeTimeout", defaultWriteTimeout)
 	viper.SetDefault("idleTimeout", defaultIdleTimeout)
 	viper.SetDefault("shutdownTimeout", defaultShutdownTimeout)
+	viper.SetDefault("maxBodyBytes", defaultMaxBodyBytes)
+	viper.SetDefault("maxConnections", 0)
+	viper.SetDefault("handlerTimeout", defaultHandlerTimeout)
 	viper.SetDefault("filename", defaultFilename)
 	viper.SetDefault("filePermissions", defaultFilePermissions)
 	viper.SetDefault("saveRetryAttempts", defaultSaveRetryAttempts)
 	viper.SetDefault("saveRetryDelay", defaultSaveRetryDelay)
+	viper.SetDefault("saveRetryMaxDelay", defaultSaveRetryMaxDelay)
+	viper.SetDefault("lockTimeout", defaultLockTimeout)
+	viper.SetDefault("readOnlyFallback", false)
+	viper.SetDefault("ownerLock", false)
+	viper.SetDefault("fsyncOnWrite", true)
+	viper.SetDefault("fsyncDirectory", false)
+	viper.SetDefault("minFreeDiskBytes", defaultMinFreeDiskBytes)
+	viper.SetDefault("serializationFormat", defaultSerializationFormat)
+	viper.SetDefault("integrityAlgorithm", defaultIntegrityAlgorithm)
+	viper.SetDefault("storageBackend", defaultStorageBackend)
+	viper.SetDefault("prettyPrintFile", true)
 	viper.SetDefault("persistInterval", defaultPersistInterval)
-	viper.SetDefault("rateLimit", defaultRateLimit)
-	viper.SetDefault("rateBurst", defaultRateBurst)
+	viper.SetDefault("persistEvery", 0)
+	viper.SetDefault("persistDebounce", defaultPersistDebounce)
+	viper.SetDefault("degradeAfterFailures", defaultDegradeAfterFailures)
+	viper.SetDefault("degradedRetryMaxDelay", defaultDegradedRetryMaxDelay)
+	viper.SetDefault("maxValue", int64(math.MaxInt64))
+	viper.SetDefault("initialValue", 0)
+	viper.SetDefault("incrementRateWindow", defaultIncrementRateWindow)
+	viper.SetDefault("rateLimit", 0)
+	viper.SetDefault("rateBurst", 0)
+	viper.SetDefault("rateLimitExemptCIDRs", []string{})
+	viper.SetDefault("idempotencyCacheSize", defaultIdempotencyCacheSize)
+	viper.SetDefault("idempotencyTTL", defaultIdempotencyTTL)
 	viper.SetDefault("enableMetrics", true)
+	viper.SetDefault("requestDurationBuckets", "")
+	viper.SetDefault("metricNamespace", "")
+	viper.SetDefault("metricSubsystem", defaultMetricSubsystem)
+	viper.SetDefault("operationDurationBuckets", "")
+	viper.SetDefault("metricsUser", "")
+	viper.SetDefault("metricsPassword", "")
+	viper.SetDefault("metricsPort", "")
+	viper.SetDefault("metricsBindAddress", "")
+	viper.SetDefault("pushgatewayURL", "")
+	viper.SetDefault("pushgatewayJob", defaultPushgatewayJob)
+	viper.SetDefault("pushgatewayInterval", 0)
 	viper.SetDefault("enableCORS", true)
+	viper.SetDefault("enableGzip", false)
+	viper.SetDefault("gzipMinBytes", defaultGzipMinBytes)
+	viper.SetDefault("enableSecurityHeaders", false)
+	viper.SetDefault("strictTransportSecurity", defaultStrictTransportSecurity)
+	viper.SetDefault("trustedProxyCIDRs", []string{})
+	viper.SetDefault("responseSigningKey", "")
 	viper.SetDefault("allowedOrigins", []string{"*"})
+	viper.SetDefault("corsAllowCredentials", false)
+	viper.SetDefault("corsAllowedMethods", []string{"GET", "POST", "OPTIONS"})
+	viper.SetDefault("corsAllowedHeaders", []string{"Content-Type", "Authorization", "X-Request-ID", "Idempotency-Key", "X-Dry-Run"})
+	viper.SetDefault("corsMaxAge", defaultCORSMaxAge)
 	viper.SetDefault("logLevel", defaultLogLevel)
+	viper.SetDefault("logFile", "")
+	viper.SetDefault("logMaxSizeMB", defaultLogMaxSizeMB)
+	viper.SetDefault("logMaxBackups", defaultLogMaxBackups)
+	viper.SetDefault("logMaxAgeDays", defaultLogMaxAgeDays)
+	viper.SetDefault("asyncLogging", false)
+	viper.SetDefault("asyncLogBufferSize", defaultAsyncLogBufferSize)
+	viper.SetDefault("logSampleRate", defaultLogSampleRate)
+	viper.SetDefault("slowRequestThreshold", defaultSlowRequestThreshold)
+	viper.SetDefault("logRequestBodies", false)
+	viper.SetDefault("redactHeaders", []string{"Authorization", "X-API-Key", "Cookie"})
+	viper.SetDefault("redactQueryParams", []string{"token", "api_key", "apikey", "password", "secret"})
 	viper.SetDefault("environment", defaultEnvironment)
+	viper.SetDefault("utcTimestamps", false)
+	viper.SetDefault("enableTracing", false)
+	viper.SetDefault("enableDocs", false)
+	viper.SetDefault("envelopeResponses", true)
+	viper.SetDefault("enableProfiling", false)
+	viper.SetDefault("enableH2C", false)
+	viper.SetDefault("enableStateImport", false)
+	viper.SetDefault("profilingAddress", defaultProfilingAddress)
+	viper.SetDefault("otlpEndpoint", defaultOTLPEndpoint)
+	viper.SetDefault("otlpInsecure", true)
 
 	// Set up configuration file
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("/etc/counter/")
+	if path == "" {
+		path = os.Getenv(ConfigFileEnv)
+	}
+	if path == "" {
+		path = findConfigFile()
+	}
+	if path != "" {
+		viper.SetConfigFile(path)
+		viper.SetConfigType(configType(path))
+	}
 
 	// Environment variables override
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("COUNTER")
 
 	// Read configuration
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+	if path != "" {
+		if err := viper.ReadInConfig(); err != nil {
 			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
 	}
 
+	// Parse histogram bucket overrides
+	requestBuckets, err := parseBuckets(viper.GetString("requestDurationBuckets"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid requestDurationBuckets: %w", err)
+	}
+	operationBuckets, err := parseBuckets(viper.GetString("operationDurationBuckets"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid operationDurationBuckets: %w", err)
+	}
+
+	// Only formats the loader can detect are accepted for writing
+	serializationFormat := viper.GetString("serializationFormat")
+	switch serializationFormat {
+	case "json", "gob", "protobuf":
+	default:
+		return nil, fmt.Errorf("invalid serializationFormat %q: expected json, gob or protobuf", serializationFormat)
+	}
+
+	integrityAlgorithm := viper.GetString("integrityAlgorithm")
+	switch integrityAlgorithm {
+	case "crc32", "sha256", "none":
+	default:
+		return nil, fmt.Errorf("invalid integrityAlgorithm %q: expected crc32, sha256 or none", integrityAlgorithm)
+	}
+
+	storageBackend := viper.GetString("storageBackend")
+	switch storageBackend {
+	case "file", "memory":
+	default:
+		return nil, fmt.Errorf("invalid storageBackend %q: expected file or memory", storageBackend)
+	}
+
+	// A seed the counter could never hold would fail on the first save
+	initialValue := viper.GetInt64("initialValue")
+	if maxValue := viper.GetInt64("maxValue"); initialValue < 0 || (maxValue > 0 && initialValue > maxValue) {
+		return nil, fmt.Errorf("invalid initialValue %d: must be between 0 and maxValue", initialValue)
+	}
+
+	// Unset rate limits scale with the CPUs available to the process, so a
+	// container with a CPU quota gets a limit it can actually serve
+	rateLimit := viper.GetInt("rateLimit")
+	if rateLimit <= 0 {
+		rateLimit = rateLimitFor(availableCPUs())
+	}
+	rateBurst := viper.GetInt("rateBurst")
+	if rateBurst <= 0 {
+		rateBurst = 2 * rateLimit
+	}
+
+	rateLimitExempt, err := parseCIDRs(viper.GetStringSlice("rateLimitExemptCIDRs"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rateLimitExemptCIDRs: %w", err)
+	}
+	trustedProxies, err := parseCIDRs(viper.GetStringSlice("trustedProxyCIDRs"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trustedProxyCIDRs: %w", err)
+	}
+
 	// Load configuration into struct
 	config := &Config{
-		Port:              viper.GetString("port"),
-		ReadTimeout:       viper.GetDuration("readTimeout"),
-		WriteTimeout:      viper.GetDuration("writeTimeout"),
-		IdleTimeout:       viper.GetDuration("idleTimeout"),
-		ShutdownTimeout:   viper.GetDuration("shutdownTimeout"),
-		Filename:          viper.GetString("filename"),
-		FilePermissions:   os.FileMode(viper.GetInt("filePermissions")),
-		SaveRetryAttempts: viper.GetInt("saveRetryAttempts"),
-		SaveRetryDelay:    viper.GetDuration("saveRetryDelay"),
-		PersistInterval:   viper.GetDuration("persistInterval"),
-		RateLimit:         viper.GetInt("rateLimit"),
-		RateBurst:         viper.GetInt("rateBurst"),
-		EnableMetrics:     viper.GetBool("enableMetrics"),
-		EnableCORS:        viper.GetBool("enableCORS"),
-		AllowedOrigins:    viper.GetStringSlice("allowedOrigins"),
-		LogLevel:          viper.GetString("logLevel"),
-		Environment:       viper.GetString("environment"),
+		Port:                     viper.GetString("port"),
+		BindAddress:              viper.GetString("bindAddress"),
+		ReadTimeout:              viper.GetDuration("readTimeout"),
+		WriteTimeout:             viper.GetDuration("writeTimeout"),
+		IdleTimeout:              viper.GetDuration("idleTimeout"),
+		ShutdownTimeout:          viper.GetDuration("shutdownTimeout"),
+		MaxBodyBytes:             viper.GetInt64("maxBodyBytes"),
+		MaxConnections:           viper.GetInt("maxConnections"),
+		HandlerTimeout:           viper.GetDuration("handlerTimeout"),
+		Filename:                 viper.GetString("filename"),
+		FilePermissions:          os.FileMode(viper.GetInt("filePermissions")),
+		SaveRetryAttempts:        viper.GetInt("saveRetryAttempts"),
+		SaveRetryDelay:           viper.GetDuration("saveRetryDelay"),
+		SaveRetryMaxDelay:        viper.GetDuration("saveRetryMaxDelay"),
+		LockTimeout:              viper.GetDuration("lockTimeout"),
+		ReadOnlyFallback:         viper.GetBool("readOnlyFallback"),
+		OwnerLock:                viper.GetBool("ownerLock"),
+		FsyncOnWrite:             viper.GetBool("fsyncOnWrite"),
+		FsyncDirectory:           viper.GetBool("fsyncDirectory"),
+		MinFreeDiskBytes:         viper.GetUint64("minFreeDiskBytes"),
+		SerializationFormat:      serializationFormat,
+		IntegrityAlgorithm:       integrityAlgorithm,
+		StorageBackend:           storageBackend,
+		PrettyPrintFile:          viper.GetBool("prettyPrintFile"),
+		PersistInterval:          viper.GetDuration("persistInterval"),
+		PersistEvery:             viper.GetInt("persistEvery"),
+		PersistDebounce:          viper.GetDuration("persistDebounce"),
+		DegradeAfterFailures:     viper.GetInt("degradeAfterFailures"),
+		DegradedRetryMaxDelay:    viper.GetDuration("degradedRetryMaxDelay"),
+		MaxValue:                 viper.GetInt64("maxValue"),
+		InitialValue:             initialValue,
+		IncrementRateWindow:      viper.GetDuration("incrementRateWindow"),
+		RateLimit:                rateLimit,
+		RateBurst:                rateBurst,
+		RateLimitExemptCIDRs:     rateLimitExempt,
+		IdempotencyCacheSize:     viper.GetInt("idempotencyCacheSize"),
+		IdempotencyTTL:           viper.GetDuration("idempotencyTTL"),
+		EnableMetrics:            viper.GetBool("enableMetrics"),
+		MetricsUser:              viper.GetString("metricsUser"),
+		MetricsPassword:          viper.GetString("metricsPassword"),
+		MetricsPort:              viper.GetString("metricsPort"),
+		MetricsBindAddress:       viper.GetString("metricsBindAddress"),
+		PushgatewayURL:           viper.GetString("pushgatewayURL"),
+		PushgatewayJob:           viper.GetString("pushgatewayJob"),
+		PushgatewayInterval:      viper.GetDuration("pushgatewayInterval"),
+		EnableCORS:               viper.GetBool("enableCORS"),
+		EnableGzip:               viper.GetBool("enableGzip"),
+		GzipMinBytes:             viper.GetInt("gzipMinBytes"),
+		EnableSecurityHeaders:    viper.GetBool("enableSecurityHeaders"),
+		StrictTransportSecurity:  viper.GetString("strictTransportSecurity"),
+		TrustedProxyCIDRs:        trustedProxies,
+		ResponseSigningKey:       viper.GetString("responseSigningKey"),
+		AllowedOrigins:           viper.GetStringSlice("allowedOrigins"),
+		CORSAllowCredentials:     viper.GetBool("corsAllowCredentials"),
+		CORSAllowedMethods:       viper.GetStringSlice("corsAllowedMethods"),
+		CORSAllowedHeaders:       viper.GetStringSlice("corsAllowedHeaders"),
+		CORSMaxAge:               viper.GetDuration("corsMaxAge"),
+		LogLevel:                 viper.GetString("logLevel"),
+		LogFile:                  viper.GetString("logFile"),
+		LogMaxSizeMB:             viper.GetInt("logMaxSizeMB"),
+		LogMaxBackups:            viper.GetInt("logMaxBackups"),
+		LogMaxAgeDays:            viper.GetInt("logMaxAgeDays"),
+		AsyncLogging:             viper.GetBool("asyncLogging"),
+		AsyncLogBufferSize:       viper.GetInt("asyncLogBufferSize"),
+		LogSampleRate:            viper.GetInt("logSampleRate"),
+		SlowRequestThreshold:     viper.GetDuration("slowRequestThreshold"),
+		LogRequestBodies:         viper.GetBool("logRequestBodies"),
+		RedactHeaders:            viper.GetStringSlice("redactHeaders"),
+		RedactQueryParams:        viper.GetStringSlice("redactQueryParams"),
+		Environment:              viper.GetString("environment"),
+		UTCTimestamps:            viper.GetBool("utcTimestamps"),
+		RequestDurationBuckets:   requestBuckets,
+		MetricNamespace:          viper.GetString("metricNamespace"),
+		MetricSubsystem:          viper.GetString("metricSubsystem"),
+		OperationDurationBuckets: operationBuckets,
+		EnableTracing:            viper.GetBool("enableTracing"),
+		EnableDocs:               viper.GetBool("enableDocs"),
+		EnvelopeResponses:        viper.GetBool("envelopeResponses"),
+		EnableProfiling:          viper.GetBool("enableProfiling"),
+		EnableH2C:                viper.GetBool("enableH2C"),
+		EnableStateImport:        viper.GetBool("enableStateImport"),
+		ProfilingAddress:         viper.GetString("profilingAddress"),
+		OTLPEndpoint:             viper.GetString("otlpEndpoint"),
+		OTLPInsecure:             viper.GetBool("otlpInsecure"),
 	}
 
 	return config, nil
-}
\ No newline at end of file
+}
+
+// parseBuckets parses histogram bucket boundaries from either a
+// comma-separated list of upper bounds or "exponential:start,factor,count".
+// An empty spec returns nil so callers fall back to the Prometheus defaults.
+func parseBuckets(spec string) ([]float64, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	// Exponentially spaced buckets
+	if strings.HasPrefix(spec, "exponential:") {
+		parts := strings.Split(strings.TrimPrefix(spec, "exponential:"), ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("expected exponential:start,factor,count, got %q", spec)
+		}
+		start, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil || start <= 0 {
+			return nil, fmt.Errorf("exponential start must be a positive number, got %q", parts[0])
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || factor <= 1 {
+			return nil, fmt.Errorf("exponential factor must be greater than 1, got %q", parts[1])
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || count < 1 {
+			return nil, fmt.Errorf("exponential count must be a positive integer, got %q", parts[2])
+		}
+		return prometheus.ExponentialBuckets(start, factor, count), nil
+	}
+
+	// Explicit upper bounds, which must be strictly increasing
+	var buckets []float64
+	for _, part := range strings.Split(spec, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q: %w", part, err)
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("bucket bounds must be strictly increasing, got %v after %v", bound, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
+
+// parseCIDRs parses networks in CIDR notation. Entries may also hold
+// comma-separated lists, as a single environment variable does. A bare IP
+// address is taken as a network of just that address.
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var specs []string
+	for _, entry := range entries {
+		specs = append(specs, strings.FieldsFunc(entry, func(r rune) bool {
+			return r == ',' || r == ' '
+		})...)
+	}
+
+	var networks []*net.IPNet
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", spec)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// findConfigFile returns the first config file found in configPaths, or ""
+// when there is none
+func findConfigFile() string {
+	for _, dir := range configPaths {
+		for _, ext := range configTypes {
+			path := filepath.Join(dir, "config."+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// configType returns the format of a config file from its extension,
+// defaulting to YAML when the extension is missing or unknown
+func configType(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	for _, t := range configTypes {
+		if ext == t {
+			return ext
+		}
+	}
+	return "yaml"
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeConfig writes content to a file called name in a temporary directory
+// and returns its path. Load works on the global viper instance, so it is
+// reset when the test ends.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	t.Cleanup(viper.Reset)
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExplicitPath(t *testing.T) {
+	path := writeConfig(t, "counter-settings.yaml", "port: \"9191\"\nfilename: /srv/visits.json\npersistInterval: 45s\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(%q) failed: %v", path, err)
+	}
+	if cfg.Port != "9191" || cfg.Filename != "/srv/visits.json" || cfg.PersistInterval != 45*time.Second {
+		t.Errorf("Loaded port %q, filename %q, persist interval %v; want the values from the file", cfg.Port, cfg.Filename, cfg.PersistInterval)
+	}
+}
+
+func TestLoadConfigFileEnv(t *testing.T) {
+	path := writeConfig(t, "mounted.yaml", "port: \"9292\"\n")
+	t.Setenv(ConfigFileEnv, path)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load with %s failed: %v", ConfigFileEnv, err)
+	}
+	if cfg.Port != "9292" {
+		t.Errorf("Port = %q, want 9292 from %s", cfg.Port, ConfigFileEnv)
+	}
+}
+
+func TestLoadMissingExplicitPath(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load of a missing explicit file succeeded, want an error")
+	}
+}
+
+func TestLoadFormatsAreEquivalent(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+port: "9393"
+allowedOrigins: ["https://app.example.com", "https://admin.example.com"]
+persistInterval: 2m
+saveRetryAttempts: 5
+enableCORS: false
+`,
+		"config.toml": `
+port = "9393"
+allowedOrigins = ["https://app.example.com", "https://admin.example.com"]
+persistInterval = "2m"
+saveRetryAttempts = 5
+enableCORS = false
+`,
+		"config.json": `{
+  "port": "9393",
+  "allowedOrigins": ["https://app.example.com", "https://admin.example.com"],
+  "persistInterval": "2m",
+  "saveRetryAttempts": 5,
+  "enableCORS": false
+}`,
+	}
+
+	loaded := map[string]*Config{}
+	for name, content := range files {
+		path := writeConfig(t, name, content)
+		viper.Reset()
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s) failed: %v", name, err)
+		}
+		loaded[name] = cfg
+	}
+
+	want := loaded["config.yaml"]
+	if want.Port != "9393" || want.PersistInterval != 2*time.Minute || want.SaveRetryAttempts != 5 || want.EnableCORS || len(want.AllowedOrigins) != 2 {
+		t.Fatalf("YAML config = %+v, want the values from the file", want)
+	}
+	for _, name := range []string{"config.toml", "config.json"} {
+		if !reflect.DeepEqual(loaded[name], want) {
+			t.Errorf("%s loaded %+v, want the same as YAML %+v", name, loaded[name], want)
+		}
+	}
+}
+
+func TestLoadUnknownExtensionIsYAML(t *testing.T) {
+	path := writeConfig(t, "counter.conf", "port: \"9494\"\n")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(%q) failed: %v", path, err)
+	}
+	if cfg.Port != "9494" {
+		t.Errorf("Port = %q, want 9494 read as YAML", cfg.Port)
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	for _, tc := range []struct {
+		cpus int
+		want int
+	}{
+		{0, 10},
+		{1, 10},
+		{4, 40},
+		{16, 160},
+	} {
+		if got := rateLimitFor(tc.cpus); got != tc.want {
+			t.Errorf("rateLimitFor(%d) = %d, want %d", tc.cpus, got, tc.want)
+		}
+	}
+}
+
+func TestLoadDerivesRateLimitFromCPUs(t *testing.T) {
+	// One CPU, whatever the cgroup quota allows
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	cfg, err := Load(writeConfig(t, "counter.yaml", "port: \"8080\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimit != 10 || cfg.RateBurst != 20 {
+		t.Errorf("Unset rate limit on one CPU = %d, burst %d; want 10, burst 20", cfg.RateLimit, cfg.RateBurst)
+	}
+
+	// An explicit limit is kept
+	cfg, err = Load(writeConfig(t, "counter.yaml", "rateLimit: 7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimit != 7 || cfg.RateBurst != 14 {
+		t.Errorf("Explicit rate limit = %d, burst %d; want 7, burst 14", cfg.RateLimit, cfg.RateBurst)
+	}
+}
+
+func TestLoadRateLimitExemptCIDRs(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "counter.yaml", "rateLimitExemptCIDRs: [\"10.0.0.0/8, 192.0.2.7\", \"fd00::/8\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, network := range cfg.RateLimitExemptCIDRs {
+		got = append(got, network.String())
+	}
+	if want := []string{"10.0.0.0/8", "192.0.2.7/32", "fd00::/8"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Exempt networks = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := Load(writeConfig(t, "counter.yaml", "rateLimitExemptCIDRs: [\""+bad+"\"]\n")); err == nil {
+			t.Errorf("Load with exempt entry %q succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestLoadGzipIsOffByDefault(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "counter.yaml", "port: \"8080\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.EnableGzip {
+		t.Error("Gzip is enabled without enableGzip being set")
+	}
+
+	cfg, err = Load(writeConfig(t, "counter.yaml", "enableGzip: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.EnableGzip {
+		t.Error("Gzip is disabled with enableGzip: true")
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted; tests point it at a
+// fake tree
+var cgroupRoot = "/sys/fs/cgroup"
+
+// availableCPUs returns the number of CPUs the process may use: GOMAXPROCS,
+// lowered to the container's CPU quota rounded up when there is one
+func availableCPUs() int {
+	cpus := runtime.GOMAXPROCS(0)
+	if quota := cgroupCPUQuota(); quota > 0 {
+		if limit := int(math.Ceil(quota)); limit < cpus {
+			cpus = limit
+		}
+	}
+	return cpus
+}
+
+// cgroupCPUQuota returns the CPU limit of the process's cgroup in CPUs, from
+// cpu.max on cgroup v2 or the CFS quota on cgroup v1. It returns 0 when
+// there is no limit or it cannot be read.
+func cgroupCPUQuota() float64 {
+	// cgroup v2: "<quota> <period>", with "max" for no limit
+	if content, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu.max")); err == nil {
+		fields := strings.Fields(string(content))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		return quotaRatio(fields[0], fields[1])
+	}
+
+	// cgroup v1: a quota of -1 means no limit
+	quota, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0
+	}
+	period, err := os.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0
+	}
+	return quotaRatio(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+// quotaRatio divides a CFS quota by its period, returning 0 for values that
+// are not positive numbers
+func quotaRatio(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
+
+// rateLimitFor returns the default rate limit for cpus CPUs
+func rateLimitFor(cpus int) int {
+	if cpus < 1 {
+		cpus = 1
+	}
+	return defaultRateLimitPerCPU * cpus
+}
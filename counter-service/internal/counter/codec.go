@@ -0,0 +1,299 @@
+package counter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yourusername/counter-service/pkg/fileutils"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Serialization formats for the counter file
+const (
+	FormatJSON     = "json"
+	FormatGob      = "gob"
+	FormatProtobuf = "protobuf"
+)
+
+// Binary formats start with a magic prefix so LoadCounter can tell them
+// apart; JSON files are recognised by their opening brace
+var (
+	gobMagic      = []byte("CTRGOB1\n")
+	protobufMagic = []byte("CTRPB1\n")
+)
+
+// codec encodes CounterData in one serialization format
+type codec interface {
+	marshal(data CounterData) ([]byte, error)
+	unmarshal(content []byte) (CounterData, error)
+}
+
+// codecFor returns the codec for a configured format name. pretty selects
+// indented rather than compact JSON.
+func codecFor(format string, pretty bool) (codec, error) {
+	switch format {
+	case "", FormatJSON:
+		return jsonCodec{compact: !pretty}, nil
+	case FormatGob:
+		return gobCodec{}, nil
+	case FormatProtobuf:
+		return protobufCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown serialization format %q", format)
+	}
+}
+
+// detectCodec returns the codec that wrote content
+func detectCodec(content []byte) codec {
+	switch {
+	case bytes.HasPrefix(content, gobMagic):
+		return gobCodec{}
+	case bytes.HasPrefix(content, protobufMagic):
+		return protobufCodec{}
+	default:
+		// MarshalIndent output always starts with a newline after the brace
+		return jsonCodec{compact: !bytes.HasPrefix(content, []byte("{\n"))}
+	}
+}
+
+// Integrity checks for the counter file, selected with cfg.IntegrityAlgorithm
+const (
+	IntegrityCRC32  = "crc32"
+	IntegritySHA256 = "sha256"
+	IntegrityNone   = "none"
+)
+
+// checksumWith calculates the CRC of data as encoded by c without its
+// integrity fields
+func checksumWith(c codec, data CounterData) (uint32, error) {
+	data.CRC, data.Hash = 0, ""
+	content, err := c.marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	return fileutils.CalculateCRC(content), nil
+}
+
+// hashWith returns the Hash field for data as encoded by c without its
+// integrity fields: the algorithm name, a colon and the hex SHA-256 digest
+func hashWith(c codec, data CounterData) (string, error) {
+	data.CRC, data.Hash = 0, ""
+	content, err := c.marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return IntegritySHA256 + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// seal sets the integrity field of data for codec c using algorithm and
+// returns the encoded result. IntegrityNone stores no integrity field.
+func seal(c codec, data CounterData, algorithm string) ([]byte, error) {
+	data.CRC, data.Hash = 0, ""
+	switch algorithm {
+	case "", IntegrityCRC32:
+		crc, err := checksumWith(c, data)
+		if err != nil {
+			return nil, err
+		}
+		data.CRC = crc
+	case IntegritySHA256:
+		hash, err := hashWith(c, data)
+		if err != nil {
+			return nil, err
+		}
+		data.Hash = hash
+	case IntegrityNone:
+	default:
+		return nil, fmt.Errorf("unknown integrity algorithm %q", algorithm)
+	}
+	return c.marshal(data)
+}
+
+// verifyIntegrity checks data, as decoded by c, against whichever integrity
+// field it was written with, so files stay readable when the configured
+// algorithm changes. Data with neither field passes. Failures match
+// ErrChecksumMismatch, or ErrCorruptData for an unknown hash algorithm.
+func verifyIntegrity(c codec, data CounterData) error {
+	switch {
+	case data.Hash != "":
+		if algorithm, _, _ := strings.Cut(data.Hash, ":"); algorithm != IntegritySHA256 {
+			return fmt.Errorf("%w: unsupported hash algorithm %q", ErrCorruptData, algorithm)
+		}
+		hash, err := hashWith(c, data)
+		if err != nil {
+			return err
+		}
+		if hash != data.Hash {
+			return fmt.Errorf("%w: expected %s, calculated %s", ErrChecksumMismatch, data.Hash, hash)
+		}
+	case data.CRC != 0:
+		crc, err := checksumWith(c, data)
+		if err != nil {
+			return err
+		}
+		if crc != data.CRC {
+			return fmt.Errorf("%w: expected %d, calculated %d", ErrChecksumMismatch, data.CRC, crc)
+		}
+	}
+	return nil
+}
+
+// jsonCodec writes JSON, indented by default for readability or compact
+type jsonCodec struct {
+	compact bool
+}
+
+func (c jsonCodec) marshal(data CounterData) ([]byte, error) {
+	if c.compact {
+		return json.Marshal(data)
+	}
+	return json.MarshalIndent(data, "", "  ")
+}
+
+func (jsonCodec) unmarshal(content []byte) (CounterData, error) {
+	var data CounterData
+	err := json.Unmarshal(content, &data)
+	return data, err
+}
+
+// gobCodec writes encoding/gob after a magic prefix
+type gobCodec struct{}
+
+func (gobCodec) marshal(data CounterData) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(gobMagic)
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) unmarshal(content []byte) (CounterData, error) {
+	var data CounterData
+	err := gob.NewDecoder(bytes.NewReader(bytes.TrimPrefix(content, gobMagic))).Decode(&data)
+	return data, err
+}
+
+// protobufCodec writes the protobuf wire format after a magic prefix,
+// equivalent to the message:
+//
+//	message CounterData {
+//	  int64 visits = 1;
+//	  int64 last_updated_unix_nano = 2;
+//	  string version = 3;
+//	  fixed32 crc = 4;
+//	  int64 peak = 5;
+//	  string hash = 6;
+//	}
+type protobufCodec struct{}
+
+// Protobuf field numbers
+const (
+	pbVisits    protowire.Number = 1
+	pbTimestamp protowire.Number = 2
+	pbVersion   protowire.Number = 3
+	pbCRC       protowire.Number = 4
+	pbPeak      protowire.Number = 5
+	pbHash      protowire.Number = 6
+)
+
+func (protobufCodec) marshal(data CounterData) ([]byte, error) {
+	b := append([]byte(nil), protobufMagic...)
+	if data.Visits != 0 {
+		b = protowire.AppendTag(b, pbVisits, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(data.Visits))
+	}
+	if !data.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, pbTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(data.Timestamp.UnixNano()))
+	}
+	if data.Version != "" {
+		b = protowire.AppendTag(b, pbVersion, protowire.BytesType)
+		b = protowire.AppendString(b, data.Version)
+	}
+	if data.CRC != 0 {
+		b = protowire.AppendTag(b, pbCRC, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, data.CRC)
+	}
+	if data.Peak != 0 {
+		b = protowire.AppendTag(b, pbPeak, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(data.Peak))
+	}
+	if data.Hash != "" {
+		b = protowire.AppendTag(b, pbHash, protowire.BytesType)
+		b = protowire.AppendString(b, data.Hash)
+	}
+	return b, nil
+}
+
+func (protobufCodec) unmarshal(content []byte) (CounterData, error) {
+	var data CounterData
+	b := bytes.TrimPrefix(content, protobufMagic)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return CounterData{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == pbVisits && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.Visits = int64(v)
+			b = b[n:]
+		case num == pbTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.Timestamp = time.Unix(0, int64(v)).UTC()
+			b = b[n:]
+		case num == pbVersion && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.Version = v
+			b = b[n:]
+		case num == pbCRC && typ == protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.CRC = v
+			b = b[n:]
+		case num == pbPeak && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.Peak = int64(v)
+			b = b[n:]
+		case num == pbHash && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			data.Hash = v
+			b = b[n:]
+		default:
+			// Skip fields added by newer versions
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return CounterData{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return data, nil
+}
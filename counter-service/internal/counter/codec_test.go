@@ -0,0 +1,169 @@
+package counter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+	"github.com/yourusername/counter-service/pkg/fileutils"
+)
+
+func TestSerializationRoundTrip(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	for _, tc := range []struct {
+		name   string
+		format string
+		pretty bool
+	}{
+		{"json", FormatJSON, false},
+		{"json pretty", FormatJSON, true},
+		{"gob", FormatGob, false},
+		{"protobuf", FormatProtobuf, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newPersistenceConfig(t)
+			cfg.SerializationFormat = tc.format
+			cfg.PrettyPrintFile = tc.pretty
+
+			c := NewCounter(0)
+			c.Set(1<<40 + 7)
+			if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			valid, err := verifyCounterFile(cfg.Filename)
+			if err != nil || !valid {
+				t.Errorf("verifyCounterFile = %v, %v; want the CRC to match", valid, err)
+			}
+
+			// Loading detects the format, so a config naming another one
+			// still reads the file
+			loadCfg := *cfg
+			loadCfg.SerializationFormat = FormatJSON
+			loaded, err := LoadCounter(&loadCfg, &logger, m)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if loaded.GetValue() != c.GetValue() || loaded.Peak() != c.Peak() {
+				t.Errorf("Loaded %d (peak %d), want %d (peak %d)", loaded.GetValue(), loaded.Peak(), c.GetValue(), c.Peak())
+			}
+		})
+	}
+}
+
+func TestPrettyPrintFile(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	sizes := map[bool]int{}
+	for _, pretty := range []bool{false, true} {
+		cfg := newPersistenceConfig(t)
+		cfg.PrettyPrintFile = pretty
+
+		c := NewCounter(0)
+		c.Set(99)
+		if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+			t.Fatalf("Save with pretty %v failed: %v", pretty, err)
+		}
+		content, err := os.ReadFile(cfg.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[pretty] = len(content)
+
+		if indented := bytes.Contains(content, []byte("\n  \"visits\"")); indented != pretty {
+			t.Errorf("pretty %v: file is indented %v:\n%s", pretty, indented, content)
+		}
+
+		// The CRC is checked over whichever layout is on disk
+		if valid, err := fileutils.VerifyFile(cfg.Filename); err != nil || !valid {
+			t.Errorf("pretty %v: VerifyFile = %v, %v; want valid", pretty, valid, err)
+		}
+		loaded, err := LoadCounter(cfg, &logger, m)
+		if err != nil || loaded.GetValue() != 99 {
+			t.Errorf("pretty %v: loaded %v, %v; want 99", pretty, loaded, err)
+		}
+	}
+
+	if sizes[false] >= sizes[true] {
+		t.Errorf("Compact file is %d bytes, pretty %d; want compact smaller", sizes[false], sizes[true])
+	}
+}
+
+func TestIntegrityAlgorithms(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	algorithms := []string{IntegrityCRC32, IntegritySHA256, IntegrityNone}
+
+	for _, written := range algorithms {
+		t.Run(written, func(t *testing.T) {
+			cfg := newPersistenceConfig(t)
+			cfg.IntegrityAlgorithm = written
+			c := NewCounter(0)
+			c.Set(7)
+			if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			data, err := ReadCounterFile(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasCRC, hasHash := data.CRC != 0, strings.HasPrefix(data.Hash, IntegritySHA256+":")
+			if hasCRC != (written == IntegrityCRC32) || hasHash != (written == IntegritySHA256) {
+				t.Errorf("File has CRC %d and hash %q, want only the %s field", data.CRC, data.Hash, written)
+			}
+
+			// The file says how it was sealed, so it loads whatever the
+			// algorithm is configured as now
+			for _, configured := range algorithms {
+				loadCfg := *cfg
+				loadCfg.IntegrityAlgorithm = configured
+				loaded, err := LoadCounter(&loadCfg, &logger, m)
+				if err != nil || loaded.GetValue() != 7 {
+					t.Errorf("Load with %s configured = %v, want 7", configured, err)
+				}
+			}
+
+			// Tampering is caught unless there is nothing to check against
+			content, err := os.ReadFile(cfg.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tampered := regexp.MustCompile(`("visits":\s*)7`).ReplaceAll(content, []byte("${1}8"))
+			if err := os.WriteFile(cfg.Filename, tampered, 0644); err != nil {
+				t.Fatal(err)
+			}
+			data, err = ReadCounterFile(cfg)
+			if written == IntegrityNone {
+				if err != nil || data.Visits != 8 {
+					t.Errorf("Read of a tampered file without integrity = %d, %v; want 8", data.Visits, err)
+				}
+			} else if !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("Read of a tampered file = %v, want ErrChecksumMismatch", err)
+			}
+		})
+	}
+}
+
+func TestIntegrityUnknownHashAlgorithm(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	content := `{"visits": 7, "last_updated": "2025-01-01T00:00:00Z", "version": "1.0.0", "hash": "md5:0123"}`
+	if err := os.WriteFile(cfg.Filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadCounterFile(cfg); !errors.Is(err, ErrCorruptData) {
+		t.Errorf("ReadCounterFile with an md5 hash = %v, want ErrCorruptData", err)
+	}
+}
@@ -1,9 +1,19 @@
 package counter
 
 import (
+	"errors"
+	"math"
 	"sync/atomic"
 )
 
+// ErrCounterOverflow is returned when an increment would exceed the
+// counter's maximum value
+var ErrCounterOverflow = errors.New("counter would exceed its maximum value")
+
+// ErrValueMismatch is returned when a conditional increment finds the
+// counter at a different value than expected
+var ErrValueMismatch = errors.New("counter value does not match the expected value")
+
 // Counter represents a thread-safe counter
 type Counter struct {
 	// Visits is the counter value
@@ -14,25 +24,65 @@ type Counter struct {
 	
 	// dirty indicates if the counter has been modified since last save
 	dirty atomic.Bool
+
+	// maxValue is the largest value the counter may reach
+	maxValue int64
+
+	// peak is the highest value the counter has held
+	peak atomic.Int64
 }
 
 // NewCounter creates a new counter with the given initial value
 func NewCounter(initialValue int64) *Counter {
-	counter := &Counter{}
+	counter := &Counter{maxValue: math.MaxInt64}
 	counter.Visits.Store(initialValue)
 	counter.lastSaved.Store(initialValue)
+	counter.peak.Store(initialValue)
 	return counter
 }
 
-// Increment atomically increments the counter and returns the new value
-func (c *Counter) Increment() int64 {
-	// Increment counter
-	newValue := c.Visits.Add(1)
-	
+// Increment atomically increments the counter and returns the new value.
+// It returns ErrCounterOverflow, leaving the value unchanged, if the
+// increment would exceed the maximum value.
+func (c *Counter) Increment() (int64, error) {
+	for {
+		current := c.Visits.Load()
+		if current >= c.maxValue {
+			return current, ErrCounterOverflow
+		}
+
+		// Retry if another increment got in first
+		if c.Visits.CompareAndSwap(current, current+1) {
+			// Mark as dirty
+			c.dirty.Store(true)
+			c.raisePeak(current + 1)
+			return current + 1, nil
+		}
+	}
+}
+
+// CompareAndIncrement increments the counter only if it currently equals
+// expected. It returns the new value and true on success, or the actual
+// current value and false otherwise. It also fails, with the actual value
+// equal to expected, when the increment would exceed the maximum value.
+func (c *Counter) CompareAndIncrement(expected int64) (int64, bool) {
+	if expected >= c.maxValue {
+		return c.Visits.Load(), false
+	}
+	if !c.Visits.CompareAndSwap(expected, expected+1) {
+		return c.Visits.Load(), false
+	}
+
 	// Mark as dirty
 	c.dirty.Store(true)
-	
-	return newValue
+	c.raisePeak(expected + 1)
+	return expected + 1, true
+}
+
+// SetMaxValue sets the largest value the counter may reach. It must be
+// called before the counter is shared between goroutines.
+func (c *Counter) SetMaxValue(max int64) {
+	c.maxValue = max
 }
 
 // GetValue returns the current counter value
@@ -40,13 +90,48 @@ func (c *Counter) GetValue() int64 {
 	return c.Visits.Load()
 }
 
+// Set replaces the counter value and marks it as dirty. Setting a lower
+// value leaves the peak where it was.
+func (c *Counter) Set(value int64) {
+	c.Visits.Store(value)
+	c.dirty.Store(true)
+	c.raisePeak(value)
+}
+
+// Peak returns the highest value the counter has held
+func (c *Counter) Peak() int64 {
+	return c.peak.Load()
+}
+
+// raisePeak records value as the peak if it is above the current peak
+func (c *Counter) raisePeak(value int64) {
+	for {
+		peak := c.peak.Load()
+		if value <= peak || c.peak.CompareAndSwap(peak, value) {
+			return
+		}
+	}
+}
+
 // IsDirty returns true if the counter has been modified since last save
 func (c *Counter) IsDirty() bool {
 	return c.dirty.Load()
 }
 
-// MarkClean marks the counter as clean (not dirty)
-func (c *Counter) MarkClean() {
-	c.lastSaved.Store(c.Visits.Load())
+// UnsavedChanges returns how far the counter has moved since the last save
+func (c *Counter) UnsavedChanges() int64 {
+	return c.Visits.Load() - c.lastSaved.Load()
+}
+
+// MarkClean records saved as the persisted value and marks the counter as
+// clean, unless it has been incremented past saved in the meantime
+func (c *Counter) MarkClean(saved int64) {
+	c.lastSaved.Store(saved)
+
+	// Clear the flag before re-checking the value, so an increment racing
+	// with this call always leaves the counter dirty
 	c.dirty.Store(false)
+	if c.Visits.Load() != saved {
+		c.dirty.Store(true)
+	}
 }
\ No newline at end of file
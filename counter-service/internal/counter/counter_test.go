@@ -0,0 +1,115 @@
+package counter
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCompareAndIncrement(t *testing.T) {
+	c := NewCounter(5)
+
+	value, ok := c.CompareAndIncrement(5)
+	if !ok || value != 6 {
+		t.Fatalf("CompareAndIncrement(5) = %d, %v; want 6, true", value, ok)
+	}
+	if !c.IsDirty() || c.Peak() != 6 {
+		t.Errorf("After a successful CAS dirty = %v, peak = %d; want true, 6", c.IsDirty(), c.Peak())
+	}
+
+	// A stale expectation reports the actual value and changes nothing
+	value, ok = c.CompareAndIncrement(5)
+	if ok || value != 6 {
+		t.Fatalf("CompareAndIncrement(5) on 6 = %d, %v; want 6, false", value, ok)
+	}
+	if c.GetValue() != 6 {
+		t.Errorf("Counter = %d after a mismatch, want 6", c.GetValue())
+	}
+}
+
+func TestIncrementOverflow(t *testing.T) {
+	c := NewCounter(0)
+	c.SetMaxValue(10)
+
+	// Concurrent increments stop exactly at the maximum
+	const callers = 25
+	var wg sync.WaitGroup
+	var overflows atomic.Int64
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Increment(); errors.Is(err, ErrCounterOverflow) {
+				overflows.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.GetValue() != 10 || overflows.Load() != callers-10 {
+		t.Fatalf("Counter = %d with %d overflows, want 10 with %d", c.GetValue(), overflows.Load(), callers-10)
+	}
+	value, err := c.Increment()
+	if !errors.Is(err, ErrCounterOverflow) || value != 10 {
+		t.Errorf("Increment at max = %d, %v; want 10, ErrCounterOverflow", value, err)
+	}
+}
+
+func TestCompareAndIncrementAtMaxValue(t *testing.T) {
+	c := NewCounter(3)
+	c.SetMaxValue(3)
+
+	value, ok := c.CompareAndIncrement(3)
+	if ok || value != 3 {
+		t.Errorf("CompareAndIncrement(3) at max = %d, %v; want 3, false", value, ok)
+	}
+}
+
+func TestCompareAndIncrementConcurrent(t *testing.T) {
+	c := NewCounter(0)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	wins := make(chan int64, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, ok := c.CompareAndIncrement(0); ok {
+				wins <- value
+			}
+		}()
+	}
+	wg.Wait()
+	close(wins)
+
+	if len(wins) != 1 {
+		t.Fatalf("%d callers succeeded with the same expected value, want 1", len(wins))
+	}
+	if c.GetValue() != 1 {
+		t.Errorf("Counter = %d, want 1", c.GetValue())
+	}
+}
+
+func TestMarkCleanKeepsLaterIncrementsDirty(t *testing.T) {
+	c := NewCounter(0)
+	c.Increment()
+	saved := c.GetValue()
+
+	// An increment lands after the value was captured for the save
+	c.Increment()
+	c.MarkClean(saved)
+
+	if !c.IsDirty() {
+		t.Error("Counter is clean with an increment the save did not include")
+	}
+	if got := c.UnsavedChanges(); got != 1 {
+		t.Errorf("UnsavedChanges = %d, want 1", got)
+	}
+
+	c.MarkClean(c.GetValue())
+	if c.IsDirty() || c.UnsavedChanges() != 0 {
+		t.Errorf("After saving the live value dirty = %v, unsaved = %d; want false, 0", c.IsDirty(), c.UnsavedChanges())
+	}
+}
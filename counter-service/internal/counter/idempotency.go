@@ -0,0 +1,117 @@
+package counter
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// idempotencyEntry is the result recorded for an idempotency key. done is
+// closed once the increment for the key has finished; until then the entry
+// marks the increment as in flight.
+type idempotencyEntry struct {
+	key     string
+	value   int64
+	expires time.Time
+	done    chan struct{}
+}
+
+// idempotencyCache remembers increment results by idempotency key. It holds
+// at most size keys, evicting the least recently used, and forgets keys
+// after ttl. The cache is in memory, so keys are only honoured by the
+// instance that first saw them.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	size    int
+	ttl     time.Duration
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+// newIdempotencyCache creates a cache holding up to size keys for ttl
+func newIdempotencyCache(size int, ttl time.Duration, now func() time.Time) *idempotencyCache {
+	return &idempotencyCache{
+		now:     now,
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// do returns the value recorded for key if it has not expired. Otherwise it
+// calls fn and records its result, including a result returned with
+// ErrNotDurable, since that increment has been applied. fn runs without the
+// lock held. Concurrent calls with the same key wait for the call in flight,
+// or for ctx to be done, so the increment is applied only once; if that call
+// fails they try again themselves.
+func (c *idempotencyCache) do(ctx context.Context, key string, fn func() (int64, error)) (value int64, replayed bool, err error) {
+	c.mu.Lock()
+	for {
+		elem, ok := c.entries[key]
+		if !ok {
+			break
+		}
+		entry := elem.Value.(*idempotencyEntry)
+		select {
+		case <-entry.done:
+		default:
+			c.mu.Unlock()
+			select {
+			case <-entry.done:
+			case <-ctx.Done():
+				return 0, false, ctx.Err()
+			}
+			c.mu.Lock()
+			// The entry may have been evicted while in flight, so replay
+			// its result rather than looking the key up again
+			if !entry.expires.IsZero() {
+				c.mu.Unlock()
+				return entry.value, true, nil
+			}
+			continue
+		}
+		if c.now().Before(entry.expires) {
+			c.order.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.value, true, nil
+		}
+		c.remove(elem)
+	}
+
+	entry := &idempotencyEntry{key: key, done: make(chan struct{})}
+	elem := c.order.PushFront(entry)
+	c.entries[key] = elem
+	c.evict()
+	c.mu.Unlock()
+
+	value, err = fn()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(entry.done)
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		if c.entries[key] == elem {
+			c.remove(elem)
+		}
+		return 0, false, err
+	}
+	entry.value, entry.expires = value, c.now().Add(c.ttl)
+	return value, false, err
+}
+
+// evict drops the least recently used entries while the cache is over size
+func (c *idempotencyCache) evict() {
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops an entry from the cache
+func (c *idempotencyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*idempotencyEntry).key)
+}
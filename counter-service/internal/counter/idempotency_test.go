@@ -0,0 +1,188 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counting returns an increment function over its own counter
+func counting() func() (int64, error) {
+	var n int64
+	return func() (int64, error) {
+		n++
+		return n, nil
+	}
+}
+
+func TestIdempotencyCacheReplay(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newIdempotencyCache(10, time.Minute, func() time.Time { return now })
+	increment := counting()
+	ctx := context.Background()
+
+	value, replayed, err := cache.do(ctx, "key-1", increment)
+	if err != nil || replayed || value != 1 {
+		t.Fatalf("First call = %d, %v, %v; want 1, false, nil", value, replayed, err)
+	}
+
+	// A retry within the window gets the recorded value without incrementing
+	now = now.Add(59 * time.Second)
+	value, replayed, err = cache.do(ctx, "key-1", increment)
+	if err != nil || !replayed || value != 1 {
+		t.Fatalf("Retry within TTL = %d, %v, %v; want 1, true, nil", value, replayed, err)
+	}
+
+	// Other keys are independent
+	if value, replayed, _ = cache.do(ctx, "key-2", increment); replayed || value != 2 {
+		t.Errorf("New key = %d, %v; want 2, false", value, replayed)
+	}
+}
+
+func TestIdempotencyCacheExpiredKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newIdempotencyCache(10, time.Minute, func() time.Time { return now })
+	increment := counting()
+	ctx := context.Background()
+
+	cache.do(ctx, "key-1", increment)
+
+	// Once the TTL has passed the key is treated as new
+	now = now.Add(time.Minute)
+	value, replayed, err := cache.do(ctx, "key-1", increment)
+	if err != nil || replayed || value != 2 {
+		t.Fatalf("Call after TTL = %d, %v, %v; want 2, false, nil", value, replayed, err)
+	}
+
+	// and the new result is what later retries replay
+	if value, replayed, _ = cache.do(ctx, "key-1", increment); !replayed || value != 2 {
+		t.Errorf("Retry after re-recording = %d, %v; want 2, true", value, replayed)
+	}
+}
+
+func TestIdempotencyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := newIdempotencyCache(2, time.Hour, func() time.Time { return now })
+	increment := counting()
+	ctx := context.Background()
+
+	cache.do(ctx, "a", increment)
+	cache.do(ctx, "b", increment)
+	cache.do(ctx, "a", increment) // a is now the most recently used
+	cache.do(ctx, "c", increment) // evicts b
+
+	if _, replayed, _ := cache.do(ctx, "a", increment); !replayed {
+		t.Error("Recently used key was evicted")
+	}
+	if value, replayed, _ := cache.do(ctx, "b", increment); replayed || value != 4 {
+		t.Errorf("Evicted key = %d, %v; want a fresh increment 4, false", value, replayed)
+	}
+}
+
+func TestIdempotencyCacheDoesNotBlockOtherKeys(t *testing.T) {
+	cache := newIdempotencyCache(10, time.Minute, time.Now)
+	ctx := context.Background()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go cache.do(ctx, "slow", func() (int64, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+	defer close(release)
+
+	// A different key completes while the first increment is still running
+	done := make(chan struct{})
+	go func() {
+		cache.do(ctx, "fast", func() (int64, error) { return 2, nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Increment for another key waited for the one in flight")
+	}
+}
+
+func TestIdempotencyCacheConcurrentSameKey(t *testing.T) {
+	cache := newIdempotencyCache(10, time.Minute, time.Now)
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	started, release := make(chan struct{}), make(chan struct{})
+	go cache.do(ctx, "key", func() (int64, error) {
+		calls.Add(1)
+		close(started)
+		<-release
+		return 7, nil
+	})
+	<-started
+
+	type result struct {
+		value    int64
+		replayed bool
+		err      error
+	}
+	results := make(chan result, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			value, replayed, err := cache.do(ctx, "key", func() (int64, error) {
+				calls.Add(1)
+				return 8, nil
+			})
+			results <- result{value, replayed, err}
+		}()
+	}
+	close(release)
+
+	for i := 0; i < 5; i++ {
+		if r := <-results; r.err != nil || !r.replayed || r.value != 7 {
+			t.Errorf("Concurrent retry = %d, %v, %v; want 7, true, nil", r.value, r.replayed, r.err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Increment applied %d times; want 1", n)
+	}
+}
+
+func TestIdempotencyCacheWaiterHonoursContext(t *testing.T) {
+	cache := newIdempotencyCache(10, time.Minute, time.Now)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go cache.do(context.Background(), "key", func() (int64, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := cache.do(ctx, "key", func() (int64, error) {
+		t.Error("Waiting retry ran its own increment")
+		return 2, nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Waiting retry error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestIdempotencyCacheRetriesAfterFailure(t *testing.T) {
+	cache := newIdempotencyCache(10, time.Minute, time.Now)
+	ctx := context.Background()
+
+	failed := errors.New("save failed")
+	if _, _, err := cache.do(ctx, "key", func() (int64, error) { return 0, failed }); !errors.Is(err, failed) {
+		t.Fatalf("First call error = %v; want %v", err, failed)
+	}
+
+	// A failed increment is not recorded, so the retry runs again
+	value, replayed, err := cache.do(ctx, "key", func() (int64, error) { return 1, nil })
+	if err != nil || replayed || value != 1 {
+		t.Errorf("Retry after failure = %d, %v, %v; want 1, false, nil", value, replayed, err)
+	}
+}
@@ -0,0 +1,122 @@
+package counter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// migration upgrades counter data written by version from (or anything
+// older) to the schema of the next migration, or of config.Version for the
+// last one
+type migration struct {
+	from    string
+	migrate func(CounterData) (CounterData, error)
+}
+
+// migrations are kept in ascending order of from. When the schema changes,
+// append a migration from the last version that wrote the old schema.
+var migrations = []migration{
+	{from: "1.0.0", migrate: func(d CounterData) (CounterData, error) { return d, nil }},
+}
+
+// migrateCounterData brings data written by an older version up to
+// config.Version. It reports whether anything changed, in which case the
+// file should be rewritten. Files without a version predate versioning and
+// go through every migration. So do files whose version does not parse, such
+// as "dev" from a local build, since they cannot be placed in the order.
+func migrateCounterData(data CounterData) (CounterData, bool, error) {
+	if !validVersion(data.Version) {
+		data.Version = ""
+	}
+
+	cmp, err := compareVersions(data.Version, config.Version)
+	if err != nil {
+		return data, false, err
+	}
+	if cmp >= 0 {
+		return data, false, nil
+	}
+
+	for _, m := range migrations {
+		if data.Version != "" {
+			cmp, err := compareVersions(m.from, data.Version)
+			if err != nil {
+				return data, false, err
+			}
+			if cmp < 0 {
+				continue
+			}
+		}
+		if data, err = m.migrate(data); err != nil {
+			return data, false, fmt.Errorf("migration from %s failed: %w", m.from, err)
+		}
+	}
+
+	data.Version = config.Version
+	return data, true, nil
+}
+
+// compareVersions compares dotted numeric versions such as 1.2.0, returning
+// -1, 0 or 1. An empty version sorts before every other version.
+func compareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+
+	// Treat a missing version as older than 0.0.0
+	switch {
+	case a == "" && b != "":
+		return -1, nil
+	case a != "" && b == "":
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// validVersion reports whether v is empty or a dotted numeric version
+func validVersion(v string) bool {
+	_, err := parseVersion(v)
+	return err == nil
+}
+
+// parseVersion splits a dotted numeric version into its parts
+func parseVersion(v string) ([]int, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(v, ".")
+	parts := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: invalid version %q", ErrVersionMismatch, v)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
@@ -0,0 +1,71 @@
+package counter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+)
+
+func TestMigrateCounterData(t *testing.T) {
+	for _, tc := range []struct {
+		version  string
+		migrated bool
+	}{
+		{"", true},
+		{"0.9.3", true},
+		{config.Version, false},
+		{"99.0.0", false},
+		// Unparseable versions are migrated as legacy data
+		{"dev", true},
+		{"1.0.0-rc1", true},
+		{"v1", true},
+	} {
+		data := CounterData{Visits: 7, Peak: 9, Version: tc.version}
+		got, migrated, err := migrateCounterData(data)
+		if err != nil {
+			t.Errorf("Version %q: migration failed: %v", tc.version, err)
+			continue
+		}
+		if migrated != tc.migrated {
+			t.Errorf("Version %q: migrated = %v, want %v", tc.version, migrated, tc.migrated)
+		}
+		if tc.migrated && got.Version != config.Version {
+			t.Errorf("Version %q: migrated to %q, want %q", tc.version, got.Version, config.Version)
+		}
+		if got.Visits != 7 || got.Peak != 9 {
+			t.Errorf("Version %q: migration changed the values to %d/%d", tc.version, got.Visits, got.Peak)
+		}
+	}
+}
+
+func TestLoadCounterMigratesOldFile(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	for _, version := range []string{"", "1.0.0", "dev"} {
+		cfg := newPersistenceConfig(t)
+		old := CounterData{Visits: 1234, Peak: 2000, Timestamp: time.Now(), Version: version}
+		if err := rewriteCounterFile(old, cfg, &logger, m); err != nil {
+			t.Fatalf("Failed to write old-format file: %v", err)
+		}
+
+		c, err := LoadCounter(cfg, &logger, m)
+		if err != nil {
+			t.Fatalf("Version %q: load failed: %v", version, err)
+		}
+		if c.GetValue() != 1234 || c.Peak() != 2000 {
+			t.Errorf("Version %q: loaded %d/%d, want 1234/2000", version, c.GetValue(), c.Peak())
+		}
+
+		data, err := ReadCounterFile(cfg)
+		if err != nil {
+			t.Fatalf("Version %q: failed to read rewritten file: %v", version, err)
+		}
+		if data.Version != config.Version {
+			t.Errorf("Version %q: file rewritten as %q, want %q", version, data.Version, config.Version)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package counter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrFileInUse is returned at startup when the owner lock file shows that a
+// live process already owns the counter file
+var ErrFileInUse = errors.New("counter file is in use by another process")
+
+// ownerLockSuffix is appended to the counter file name for the owner lock
+const ownerLockSuffix = ".lock"
+
+// ownerInfo is the content of the owner lock file
+type ownerInfo struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ownerLock is a sidecar file naming the process that owns the counter
+// file. Unlike flock it does not depend on the filesystem honoring locks,
+// so it also keeps a second instance out on NFS.
+type ownerLock struct {
+	path  string
+	owner ownerInfo
+}
+
+// acquireOwnerLock claims the counter file for this process. A lock left
+// behind by a process that is no longer running is replaced. A lock held by
+// a live process, or by a process on another host whose liveness cannot be
+// checked, fails with an error matching ErrFileInUse.
+func acquireOwnerLock(filename string, now time.Time) (*ownerLock, error) {
+	hostname, _ := os.Hostname()
+	l := &ownerLock{
+		path:  filename + ownerLockSuffix,
+		owner: ownerInfo{PID: os.Getpid(), Hostname: hostname, StartedAt: now},
+	}
+	content, err := json.Marshal(l.owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// One retry, after removing a stale lock
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(content)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return nil, &PersistError{Op: "lock", Path: l.path, Err: err}
+			}
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, &PersistError{Op: "lock", Path: l.path, Err: err}
+		}
+
+		current, err := readOwnerLock(l.path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Released between our create and read
+			continue
+		}
+		if err == nil && current.alive(hostname) {
+			return nil, &PersistError{Op: "lock", Path: l.path, Err: fmt.Errorf("%w: pid %d on %q since %s; remove %s if that process is gone",
+				ErrFileInUse, current.PID, current.Hostname, current.StartedAt.Format(time.RFC3339), l.path)}
+		}
+
+		// Dead owner or unreadable lock file
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, &PersistError{Op: "lock", Path: l.path, Err: err}
+		}
+	}
+
+	return nil, &PersistError{Op: "lock", Path: l.path, Err: fmt.Errorf("%w: lock file keeps reappearing", ErrFileInUse)}
+}
+
+// release removes the lock file if it still names this process
+func (l *ownerLock) release() error {
+	current, err := readOwnerLock(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if current.PID != l.owner.PID || !current.StartedAt.Equal(l.owner.StartedAt) {
+		return nil
+	}
+	return os.Remove(l.path)
+}
+
+// readOwnerLock reads and decodes an owner lock file
+func readOwnerLock(path string) (ownerInfo, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ownerInfo{}, err
+	}
+	var info ownerInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return ownerInfo{}, err
+	}
+	return info, nil
+}
+
+// alive reports whether the owner may still be running. Owners on another
+// host are assumed alive, since their process cannot be checked from here.
+func (o ownerInfo) alive(hostname string) bool {
+	if o.Hostname != hostname {
+		return true
+	}
+	// A lock naming our own PID is left over from an earlier run that had
+	// the same PID, as is common for PID 1 in containers
+	if o.PID <= 0 || o.PID == os.Getpid() {
+		return false
+	}
+	err := syscall.Kill(o.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,112 @@
+package counter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeOwnerLock leaves a lock file for filename naming owner
+func writeOwnerLock(t *testing.T, filename string, owner ownerInfo) {
+	t.Helper()
+
+	content, err := json.Marshal(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename+ownerLockSuffix, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPID returns the PID of a process that has already exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run a child process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestOwnerLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+	now := time.Unix(1700000000, 0)
+
+	for _, tc := range []struct {
+		name       string
+		owner      *ownerInfo
+		unreadable bool
+		inUse      bool
+	}{
+		{"no lock", nil, false, false},
+		{"stale lock", &ownerInfo{PID: exitedPID(t), Hostname: hostname}, false, false},
+		{"own pid from an earlier run", &ownerInfo{PID: os.Getpid(), Hostname: hostname}, false, false},
+		{"unreadable lock", nil, true, false},
+		{"live lock", &ownerInfo{PID: os.Getppid(), Hostname: hostname}, false, true},
+		{"other host", &ownerInfo{PID: 1, Hostname: hostname + "-elsewhere"}, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "counter.json")
+			if tc.owner != nil {
+				writeOwnerLock(t, filename, *tc.owner)
+			}
+			if tc.unreadable {
+				if err := os.WriteFile(filename+ownerLockSuffix, []byte("not json"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, err := acquireOwnerLock(filename, now)
+			if tc.inUse {
+				if !errors.Is(err, ErrFileInUse) {
+					t.Fatalf("acquireOwnerLock = %v, want ErrFileInUse", err)
+				}
+				// The other owner's lock is left in place
+				if current, err := readOwnerLock(filename + ownerLockSuffix); err != nil || current.PID != tc.owner.PID {
+					t.Errorf("Lock file now names %+v, %v; want the live owner", current, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("acquireOwnerLock failed: %v", err)
+			}
+			current, err := readOwnerLock(filename + ownerLockSuffix)
+			if err != nil || current.PID != os.Getpid() || !current.StartedAt.Equal(now) {
+				t.Errorf("Lock file names %+v, %v; want this process", current, err)
+			}
+
+			if err := l.release(); err != nil {
+				t.Fatalf("release failed: %v", err)
+			}
+			if _, err := os.Stat(filename + ownerLockSuffix); !os.IsNotExist(err) {
+				t.Errorf("Lock file still exists after release: %v", err)
+			}
+		})
+	}
+}
+
+func TestOwnerLockReleaseKeepsOtherOwner(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "counter.json")
+	l, err := acquireOwnerLock(filename, time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process took over after our lock was removed by hand
+	hostname, _ := os.Hostname()
+	writeOwnerLock(t, filename, ownerInfo{PID: os.Getppid(), Hostname: hostname})
+
+	if err := l.release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if current, err := readOwnerLock(filename + ownerLockSuffix); err != nil || current.PID != os.Getppid() {
+		t.Errorf("release removed a lock it did not own: %+v, %v", current, err)
+	}
+}
@@ -1,108 +1,375 @@
 package counter
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/internal/tracing"
+	"github.com/yourusername/counter-service/pkg/clock"
 	"github.com/yourusername/counter-service/pkg/fileutils"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// tracer creates spans for counter and persistence operations
+var tracer = tracing.Tracer("github.com/yourusername/counter-service/internal/counter")
+
 // CounterData is the structure used for serialization
 type CounterData struct {
 	Visits    int64     `json:"visits"`
+	Peak      int64     `json:"peak,omitempty"`
 	Timestamp time.Time `json:"last_updated"`
 	Version   string    `json:"version"`
 	CRC       uint32    `json:"crc,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// Errors returned by persistence operations, usually wrapped in a
+// *PersistError. Check for them with errors.Is.
+var (
+	ErrLockTimeout = errors.New("timed out waiting for counter file lock")
+	ErrCorruptData = errors.New("corrupt counter data")
+	ErrDiskFull    = errors.New("disk full")
+)
+
+// Errors returned when validating counter data
+var (
+	ErrChecksumMismatch = fmt.Errorf("%w: checksum mismatch", ErrCorruptData)
+	ErrVersionMismatch  = errors.New("counter data version mismatch")
+)
+
+// ErrReadOnly is returned by saves while persistence is disabled because the
+// counter file cannot be written
+var ErrReadOnly = errors.New("counter persistence is read-only")
+
+// ErrInsufficientSpace is returned when a save is skipped because the disk
+// is nearly full. It matches ErrDiskFull.
+var ErrInsufficientSpace = fmt.Errorf("%w: free space below minimum", ErrDiskFull)
+
+// PersistError records a failed operation on the counter file
+type PersistError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PersistError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *PersistError) Unwrap() error {
+	return e.Err
+}
+
+// lockPollInterval is how often a contended file lock is retried
+const lockPollInterval = 10 * time.Millisecond
+
+// lockFile takes a flock on f, giving up with ErrLockTimeout after timeout.
+// A zero timeout waits indefinitely.
+func lockFile(f *os.File, how int, timeout time.Duration) error {
+	if timeout <= 0 {
+		return syscall.Flock(int(f.Fd()), how)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w after %s", ErrLockTimeout, timeout)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// diskError marks out-of-space write failures as ErrDiskFull
+func diskError(err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("%w: %v", ErrDiskFull, err)
+	}
+	return err
+}
+
+// maxCounterFileSize bounds how much of a counter file is read for
+// verification
+const maxCounterFileSize = 1 << 20
+
+// availableSpace reports free space for the disk preflight check; tests
+// replace it to simulate a full disk
+var availableSpace = fileutils.AvailableSpace
+
+// newCounterData builds the serialized form of visits and peak as of now,
+// including its CRC
+func newCounterData(visits, peak int64, now time.Time) (CounterData, error) {
+	data := CounterData{
+		Visits:    visits,
+		Peak:      peak,
+		Timestamp: now,
+		Version:   config.Version,
+	}
+
+	crc, err := data.checksum()
+	if err != nil {
+		return CounterData{}, err
+	}
+	data.CRC = crc
+	return data, nil
+}
+
+// timestamp returns t in UTC when cfg.UTCTimestamps is set, and unchanged
+// otherwise
+func timestamp(cfg *config.Config, t time.Time) time.Time {
+	if cfg.UTCTimestamps {
+		return t.UTC()
+	}
+	return t
+}
+
+// checksum calculates the CRC of the data as JSON without a CRC field, the
+// form used by export and import
+func (d CounterData) checksum() (uint32, error) {
+	return checksumWith(jsonCodec{}, d)
+}
+
+// Verify checks that the data carries a valid CRC and was written by this
+// version of the service
+func (d CounterData) Verify() error {
+	crc, err := d.checksum()
+	if err != nil {
+		return err
+	}
+	if d.CRC == 0 || crc != d.CRC {
+		return fmt.Errorf("%w: expected %d, calculated %d", ErrChecksumMismatch, d.CRC, crc)
+	}
+	if d.Version != config.Version {
+		return fmt.Errorf("%w: got %q, want %q", ErrVersionMismatch, d.Version, config.Version)
+	}
+	return nil
 }
 
-// SaveCounter persists the counter to disk
-func SaveCounter(counter *Counter, cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) error {
+// SaveCounter persists the counter to disk. Remaining retry attempts are
+// abandoned once ctx is done.
+func SaveCounter(ctx context.Context, counter *Counter, cfg *config.Config, clk clock.Clock, logger *zerolog.Logger, metrics *metrics.Metrics) error {
+	ctx, span := tracer.Start(ctx, "SaveCounter")
+	defer span.End()
+
 	startTime := time.Now()
 	defer func() {
 		metrics.OperationDuration.WithLabelValues("save").Observe(time.Since(startTime).Seconds())
 	}()
-	
+
 	// Increment operation counter
 	metrics.CounterOperations.WithLabelValues("save").Inc()
-	
+
 	// Prepare data
 	data := CounterData{
 		Visits:    counter.GetValue(),
-		Timestamp: time.Now(),
+		Peak:      counter.Peak(),
+		Timestamp: timestamp(cfg, clk.Now()),
 		Version:   config.Version,
 	}
-	
-	// Marshal to JSON
-	jsonBytes, err := json.MarshalIndent(data, "", "  ")
+
+	// Encode in the configured format with its CRC
+	c, err := codecFor(cfg.SerializationFormat, cfg.PrettyPrintFile)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to marshal counter data")
 		metrics.PersistErrors.Inc()
-		return err
+		return &PersistError{Op: "save", Path: cfg.Filename, Err: err}
 	}
-	
-	// Calculate CRC
-	crc := fileutils.CalculateCRC(jsonBytes)
-	data.CRC = crc
-	
-	// Marshal again with CRC
-	jsonBytes, err = json.MarshalIndent(data, "", "  ")
+	content, err := seal(c, data, cfg.IntegrityAlgorithm)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to marshal counter data with CRC")
+		logger.Error().Err(err).Msg("Failed to marshal counter data")
 		metrics.PersistErrors.Inc()
-		return err
+		return &PersistError{Op: "save", Path: cfg.Filename, Err: err}
 	}
-	
+
+	// Fail fast on a nearly full disk instead of burning retries on
+	// writes that cannot succeed
+	if err := checkDiskSpace(cfg, logger); err != nil {
+		metrics.InsufficientSpaceErrors.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "insufficient disk space")
+		return &PersistError{Op: "save", Path: cfg.Filename, Err: err}
+	}
+
 	// Implement retry logic
 	var saveErr error
 	for attempt := 0; attempt < cfg.SaveRetryAttempts; attempt++ {
-		saveErr = writeCounterToDisk(jsonBytes, cfg, logger, metrics)
+		if err := ctx.Err(); err != nil {
+			logger.Warn().Err(err).Int("attempt", attempt+1).Msg("Save cancelled")
+			return fmt.Errorf("save cancelled: %w", err)
+		}
+
+		saveErr = writeCounterToDisk(ctx, content, cfg, logger, metrics)
 		if saveErr == nil {
 			// Successfully saved, mark counter as clean
-			counter.MarkClean()
+			counter.MarkClean(data.Visits)
 			return nil
 		}
-		
+
 		logger.Warn().
 			Err(saveErr).
 			Int("attempt", attempt+1).
 			Int("maxAttempts", cfg.SaveRetryAttempts).
 			Msg("Save attempt failed, retrying")
-			
+
 		metrics.PersistErrors.Inc()
-		time.Sleep(cfg.SaveRetryDelay)
+
+		if attempt+1 < cfg.SaveRetryAttempts {
+			sleep(ctx, clk, saveRetryDelay(cfg, attempt+1))
+		}
 	}
-	
+
 	logger.Error().
 		Err(saveErr).
 		Int("attempts", cfg.SaveRetryAttempts).
 		Msg("Failed to save counter after multiple attempts")
-	
-	return fmt.Errorf("failed to save counter after %d attempts: %w", cfg.SaveRetryAttempts, saveErr)
+	span.RecordError(saveErr)
+	span.SetStatus(codes.Error, "save failed")
+
+	return &PersistError{
+		Op:   "save",
+		Path: cfg.Filename,
+		Err:  fmt.Errorf("failed after %d attempts: %w", cfg.SaveRetryAttempts, saveErr),
+	}
+}
+
+// saveRetryDelay returns the delay before save retry number retry:
+// exponential from cfg.SaveRetryDelay, capped at cfg.SaveRetryMaxDelay, with
+// jitter over its upper half so instances that failed together against
+// shared storage do not retry in lockstep
+func saveRetryDelay(cfg *config.Config, retry int) time.Duration {
+	maxDelay := cfg.SaveRetryMaxDelay
+	if maxDelay < cfg.SaveRetryDelay {
+		maxDelay = cfg.SaveRetryDelay
+	}
+
+	delay := cfg.SaveRetryDelay
+	for i := 1; i < retry && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// sleep waits for d on clk, returning early once ctx is done
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	timer := clk.AfterFunc(d, func() { close(done) })
+	select {
+	case <-done:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+}
+
+// checkDiskSpace returns ErrInsufficientSpace when the disk holding the
+// counter file has less than cfg.MinFreeDiskBytes available. If free space
+// cannot be determined, including on platforms where it is never known, the
+// save goes ahead.
+func checkDiskSpace(cfg *config.Config, logger *zerolog.Logger) error {
+	if cfg.MinFreeDiskBytes == 0 {
+		return nil
+	}
+
+	available, err := availableSpace(filepath.Dir(cfg.Filename))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		logger.Warn().Err(err).Msg("Could not check free disk space")
+		return nil
+	}
+	if available < cfg.MinFreeDiskBytes {
+		logger.Error().
+			Uint64("available", available).
+			Uint64("required", cfg.MinFreeDiskBytes).
+			Msg("Not enough free disk space to save counter")
+		return fmt.Errorf("%w: %d bytes available, %d required", ErrInsufficientSpace, available, cfg.MinFreeDiskBytes)
+	}
+	return nil
+}
+
+// checkWritable proves the directory of the counter file accepts the same
+// temp-file-and-rename writes that saves use, leaving no file behind
+func checkWritable(cfg *config.Config) error {
+	probe := cfg.Filename + ".probe"
+	if err := fileutils.AtomicWriteFile(probe, nil, cfg.FilePermissions, false); err != nil {
+		return err
+	}
+	return os.Remove(probe)
+}
+
+// verifyCounterFile reports whether the counter file at path passes its CRC
+// or hash check, in whichever format it was written
+func verifyCounterFile(path string) (bool, error) {
+	content, err := fileutils.ReadFileWithLimit(path, maxCounterFileSize)
+	if err != nil {
+		return false, &PersistError{Op: "verify", Path: path, Err: err}
+	}
+
+	c := detectCodec(content)
+	data, err := c.unmarshal(content)
+	if err != nil || (data.CRC == 0 && data.Hash == "") {
+		return false, nil
+	}
+	if _, ok := c.(jsonCodec); ok && data.Hash == "" {
+		// Also catches JSON that was reformatted by hand
+		return fileutils.VerifyFile(path)
+	}
+	return verifyIntegrity(c, data) == nil, nil
+}
+
+// rewriteCounterFile writes data back to the counter file in the configured
+// format, keeping its original timestamp
+func rewriteCounterFile(data CounterData, cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) error {
+	c, err := codecFor(cfg.SerializationFormat, cfg.PrettyPrintFile)
+	if err != nil {
+		return err
+	}
+	content, err := seal(c, data, cfg.IntegrityAlgorithm)
+	if err != nil {
+		return err
+	}
+	return writeCounterToDisk(context.Background(), content, cfg, logger, metrics)
 }
 
 // writeCounterToDisk handles atomic file writing with proper locking
-func writeCounterToDisk(data []byte, cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) error {
+func writeCounterToDisk(ctx context.Context, data []byte, cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) error {
+	_, span := tracer.Start(ctx, "writeCounterToDisk")
+	defer span.End()
+
 	startTime := time.Now()
 	defer func() {
 		metrics.OperationDuration.WithLabelValues("write").Observe(time.Since(startTime).Seconds())
 	}()
-	
+
 	metrics.CounterOperations.WithLabelValues("write").Inc()
-	
+
 	// Create temporary file for atomic writing
 	tempFile := cfg.Filename + ".tmp"
 	f, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cfg.FilePermissions)
 	if err != nil {
 		return fmt.Errorf("failed to open temp file: %w", err)
 	}
-	
+
 	defer func() {
 		f.Close()
 		// Clean up temp file on error
@@ -110,102 +377,176 @@ func writeCounterToDisk(data []byte, cfg *config.Config, logger *zerolog.Logger,
 			os.Remove(tempFile)
 		}
 	}()
-	
+
 	// Apply exclusive lock for writing
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+	if err := lockFile(f, syscall.LOCK_EX, cfg.LockTimeout); err != nil {
 		return fmt.Errorf("failed to acquire write lock: %w", err)
 	}
 	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
-	
+
 	// Write data
 	if _, err = f.Write(data); err != nil {
-		return fmt.Errorf("failed to write data: %w", err)
+		return fmt.Errorf("failed to write data: %w", diskError(err))
 	}
-	
+
 	// Ensure data is written to disk
-	if err = f.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file: %w", err)
+	if cfg.FsyncOnWrite {
+		if err = f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync file: %w", diskError(err))
+		}
 	}
-	
+
 	// Close file explicitly before rename
 	f.Close()
-	
+
 	// Atomically replace the old file with the new one
-	if err := os.Rename(tempFile, cfg.Filename); err != nil {
+	crossDevice, err := fileutils.MoveFile(tempFile, cfg.Filename)
+	if err != nil {
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
-	
+	if crossDevice {
+		logger.Warn().
+			Str("file", cfg.Filename).
+			Msg("Temp file is on a different filesystem, replaced the counter file by copying, which is not atomic")
+	}
+
+	// Make the rename itself survive a crash
+	if cfg.FsyncDirectory {
+		if err := fileutils.SyncDir(filepath.Dir(cfg.Filename)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// errEmptyFile is returned by ReadCounterFile for a zero-length file, which
+// LoadCounter treats as a fresh start rather than corruption
+var errEmptyFile = fmt.Errorf("%w: file is empty", ErrCorruptData)
+
+// ReadCounterFile reads and decodes the counter file under a shared lock,
+// validating its CRC or hash when present. Files that cannot be decoded or
+// fail the check return an error matching ErrCorruptData. The data is
+// returned as stored, without migrating it.
+func ReadCounterFile(cfg *config.Config) (CounterData, error) {
+	f, err := os.OpenFile(cfg.Filename, os.O_RDONLY, cfg.FilePermissions)
+	if err != nil {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: err}
+	}
+	defer f.Close()
+
+	// Apply shared lock for reading
+	if err := lockFile(f, syscall.LOCK_SH, cfg.LockTimeout); err != nil {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: fmt.Errorf("failed to acquire read lock: %w", err)}
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	// Read file content
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: err}
+	}
+	if len(content) == 0 {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: errEmptyFile}
+	}
+
+	// Decode in whichever format the file was written
+	c := detectCodec(content)
+	data, err := c.unmarshal(content)
+	if err != nil {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: fmt.Errorf("%w: %v", ErrCorruptData, err)}
+	}
+
+	// Validate the CRC or hash, whichever the file was written with
+	if err := verifyIntegrity(c, data); errors.Is(err, ErrCorruptData) {
+		return CounterData{}, &PersistError{Op: "load", Path: cfg.Filename, Err: err}
+	}
+
+	return data, nil
+}
+
+// corruptionKind labels a corrupt-data error from ReadCounterFile for the
+// corruption metric
+func corruptionKind(err error) string {
+	if errors.Is(err, ErrChecksumMismatch) {
+		return "checksum"
+	}
+	return "decode"
+}
+
 // LoadCounter reads the counter from disk
 func LoadCounter(cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) (*Counter, error) {
 	startTime := time.Now()
 	defer func() {
 		metrics.OperationDuration.WithLabelValues("load").Observe(time.Since(startTime).Seconds())
 	}()
-	
+
 	metrics.CounterOperations.WithLabelValues("load").Inc()
-	
+
 	// Check if file exists
 	if _, err := os.Stat(cfg.Filename); os.IsNotExist(err) {
-		logger.Info().Msg("Counter file does not exist, starting with zero")
-		return NewCounter(0), nil
-	}
-	
-	f, err := os.OpenFile(cfg.Filename, os.O_RDONLY, cfg.FilePermissions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open counter file: %w", err)
-	}
-	defer f.Close()
-	
-	// Apply shared lock for reading
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
-		return nil, fmt.Errorf("failed to acquire read lock: %w", err)
-	}
-	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
-	
-	// Check if file is empty
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat file: %w", err)
+		if cfg.InitialValue == 0 {
+			logger.Info().Msg("Counter file does not exist, starting with zero")
+			return NewCounter(0), nil
+		}
+		return seedCounter(cfg, logger, metrics), nil
 	}
-	
-	if fi.Size() == 0 {
+
+	data, err := ReadCounterFile(cfg)
+	switch {
+	case errors.Is(err, errEmptyFile):
 		logger.Info().Msg("Empty counter file, starting with zero")
 		return NewCounter(0), nil
+	case errors.Is(err, ErrCorruptData):
+		kind := corruptionKind(err)
+		metrics.CorruptionDetected.WithLabelValues(kind).Inc()
+		logger.Warn().Err(err).Str("kind", kind).Msg("Counter file is corrupt, starting with zero")
+		return NewCounter(0), nil
+	case err != nil:
+		return nil, err
 	}
-	
-	// Read file content
-	content, err := io.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read counter file: %w", err)
+
+	// Upgrade files written by older versions and save them in the new schema
+	// so the migration only runs once
+	oldVersion := data.Version
+	if !validVersion(oldVersion) {
+		logger.Warn().Str("version", oldVersion).Msg("Counter file has an unrecognised version, migrating it as legacy data")
 	}
-	
-	var data CounterData
-	if err := json.Unmarshal(content, &data); err != nil {
-		logger.Warn().Err(err).Msg("Failed to decode counter data, starting with zero")
-		return NewCounter(0), nil
+	data, migrated, err := migrateCounterData(data)
+	if err != nil {
+		return nil, &PersistError{Op: "migrate", Path: cfg.Filename, Err: err}
 	}
-	
-	// Validate CRC if present
-	if data.CRC > 0 {
-		// Create a copy without CRC for validation
-		dataCopy := data
-		dataCopy.CRC = 0
-		jsonBytes, err := json.MarshalIndent(dataCopy, "", "  ")
-		if err == nil {
-			calculatedCRC := fileutils.CalculateCRC(jsonBytes)
-			if calculatedCRC != data.CRC {
-				logger.Warn().
-					Uint32("expected", data.CRC).
-					Uint32("calculated", calculatedCRC).
-					Msg("CRC validation failed, starting with zero")
-				return NewCounter(0), nil
-			}
+	if migrated {
+		logger.Info().
+			Str("from", oldVersion).
+			Str("to", data.Version).
+			Msg("Migrated counter data")
+		if err := rewriteCounterFile(data, cfg, logger, metrics); err != nil {
+			// The migrated value is still usable; the next save retries
+			logger.Warn().Err(err).Msg("Failed to rewrite migrated counter file")
 		}
 	}
-	
-	logger.Info().Int64("visits", data.Visits).Msg("Counter loaded successfully")
-	return NewCounter(data.Visits), nil
-}
\ No newline at end of file
+
+	logger.Info().Int64("visits", data.Visits).Int64("peak", data.Peak).Msg("Counter loaded successfully")
+
+	// Files written before the peak was recorded start with the current value
+	counter := NewCounter(data.Visits)
+	counter.raisePeak(data.Peak)
+	return counter, nil
+}
+
+// seedCounter starts a new counter at cfg.InitialValue and saves it straight
+// away, so the seed is not applied again on the next start. If the save
+// fails the counter is left dirty for the next save to retry.
+func seedCounter(cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) *Counter {
+	logger.Info().Int64("visits", cfg.InitialValue).Msg("Counter file does not exist, starting from initial value")
+
+	data := CounterData{Visits: cfg.InitialValue, Timestamp: timestamp(cfg, time.Now()), Version: config.Version}
+	if err := rewriteCounterFile(data, cfg, logger, metrics); err != nil {
+		logger.Warn().Err(err).Msg("Failed to save initial value")
+		c := NewCounter(0)
+		c.Set(cfg.InitialValue)
+		return c
+	}
+	return NewCounter(cfg.InitialValue)
+}
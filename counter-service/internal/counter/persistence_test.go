@@ -0,0 +1,385 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+// newPersistenceConfig returns a config saving to a counter file in a
+// temporary directory, with one save attempt and fsync enabled
+func newPersistenceConfig(tb testing.TB) *config.Config {
+	tb.Helper()
+
+	return &config.Config{
+		Filename:            filepath.Join(tb.TempDir(), "counter.json"),
+		FilePermissions:     0644,
+		SaveRetryAttempts:   1,
+		LockTimeout:         time.Second,
+		FsyncOnWrite:        true,
+		FsyncDirectory:      true,
+		SerializationFormat: "json",
+		IntegrityAlgorithm:  IntegrityCRC32,
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		fsync bool
+	}{
+		{"FsyncOnWrite", true},
+		{"NoFsync", false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := newPersistenceConfig(b)
+			cfg.FsyncOnWrite = bc.fsync
+			cfg.FsyncDirectory = bc.fsync
+			logger := zerolog.Nop()
+			m := metrics.NewMetrics(&config.Config{})
+			c := NewCounter(0)
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Increment()
+				if err := SaveCounter(ctx, c, cfg, clock.Real{}, &logger, m); err != nil {
+					b.Fatalf("Save failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// setAvailableSpace makes the disk preflight check see free bytes until the
+// test ends
+func setAvailableSpace(t *testing.T, free uint64) {
+	t.Helper()
+
+	saved := availableSpace
+	availableSpace = func(string) (uint64, error) { return free, nil }
+	t.Cleanup(func() { availableSpace = saved })
+}
+
+func TestSaveCounterInsufficientSpace(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	cfg.MinFreeDiskBytes = 1 << 20
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	setAvailableSpace(t, 4096)
+
+	c := NewCounter(0)
+	c.Increment()
+	err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m)
+	if !errors.Is(err, ErrInsufficientSpace) || !errors.Is(err, ErrDiskFull) {
+		t.Fatalf("Save error = %v, want ErrInsufficientSpace matching ErrDiskFull", err)
+	}
+
+	// The save is skipped before anything is written
+	if _, err := os.Stat(cfg.Filename); !os.IsNotExist(err) {
+		t.Errorf("Counter file exists after a skipped save: %v", err)
+	}
+	if !c.IsDirty() {
+		t.Error("Counter is clean after a skipped save")
+	}
+	var d dto.Metric
+	if err := m.InsufficientSpaceErrors.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Insufficient space errors = %v, want 1", got)
+	}
+
+	// Once there is room again the save goes through
+	setAvailableSpace(t, 2<<20)
+	if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+		t.Fatalf("Save with enough space failed: %v", err)
+	}
+	if c.IsDirty() {
+		t.Error("Counter is dirty after a successful save")
+	}
+}
+
+func TestSaveCounterUnknownSpace(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	cfg.MinFreeDiskBytes = 1 << 20
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	// Where free space is never known the preflight is skipped
+	saved := availableSpace
+	availableSpace = func(path string) (uint64, error) { return 0, fmt.Errorf("%s: %w", path, errors.ErrUnsupported) }
+	t.Cleanup(func() { availableSpace = saved })
+
+	c := NewCounter(0)
+	c.Increment()
+	if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+		t.Fatalf("Save with unknown free space failed: %v", err)
+	}
+	if c.IsDirty() {
+		t.Error("Counter is dirty after the save")
+	}
+}
+
+func TestSaveCounterRacingIncrements(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	cfg.FsyncOnWrite = false
+	cfg.FsyncDirectory = false
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	c := NewCounter(0)
+
+	const writers, increments = 4, 500
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				c.Increment()
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for saving := true; saving; {
+		select {
+		case <-done:
+			saving = false
+		default:
+		}
+		if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// The last save may have missed the final increments, but then the
+	// counter must still be dirty rather than claim they are on disk
+	loaded, err := LoadCounter(cfg, &logger, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(writers * increments); c.GetValue() != want {
+		t.Fatalf("Counter = %d, want %d", c.GetValue(), want)
+	}
+	if !c.IsDirty() && loaded.GetValue() != c.GetValue() {
+		t.Errorf("Counter is clean at %d but the file holds %d", c.GetValue(), loaded.GetValue())
+	}
+	if got := c.GetValue() - loaded.GetValue(); c.UnsavedChanges() != got {
+		t.Errorf("UnsavedChanges = %d, want %d", c.UnsavedChanges(), got)
+	}
+}
+
+func TestLoadCounterInitialValue(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	// With no file the seed is used and saved straight away
+	cfg := newPersistenceConfig(t)
+	cfg.InitialValue = 5000
+	c, err := LoadCounter(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.GetValue() != 5000 || c.IsDirty() {
+		t.Errorf("Seeded counter = %d, dirty %v; want 5000 and already saved", c.GetValue(), c.IsDirty())
+	}
+	data, err := ReadCounterFile(cfg)
+	if err != nil || data.Visits != 5000 {
+		t.Fatalf("Seeded file holds %d, %v; want 5000", data.Visits, err)
+	}
+
+	// Once a file exists the seed is ignored, even if it changes
+	c.Increment()
+	if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+		t.Fatal(err)
+	}
+	cfg.InitialValue = 9000
+	c, err = LoadCounter(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if c.GetValue() != 5001 {
+		t.Errorf("Loaded %d with an existing file, want its 5001 rather than the seed", c.GetValue())
+	}
+}
+
+func TestLoadCounterCorruptionMetric(t *testing.T) {
+	logger := zerolog.Nop()
+
+	for _, tc := range []struct {
+		name    string
+		corrupt func(content []byte) []byte
+		kind    string
+	}{
+		{"checksum", func(content []byte) []byte {
+			return regexp.MustCompile(`("visits":\s*)42`).ReplaceAll(content, []byte("${1}43"))
+		}, "checksum"},
+		{"decode", func(content []byte) []byte {
+			return content[:len(content)/2]
+		}, "decode"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newPersistenceConfig(t)
+			m := metrics.NewMetrics(&config.Config{})
+			c := NewCounter(0)
+			c.Set(42)
+			if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(cfg.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(cfg.Filename, tc.corrupt(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadCounter(cfg, &logger, m)
+			if err != nil || loaded.GetValue() != 0 {
+				t.Fatalf("Load of a corrupt file = %v, %v; want a fresh counter", loaded, err)
+			}
+			for _, kind := range []string{"checksum", "decode"} {
+				var d dto.Metric
+				if err := m.CorruptionDetected.WithLabelValues(kind).Write(&d); err != nil {
+					t.Fatal(err)
+				}
+				want := 0.0
+				if kind == tc.kind {
+					want = 1
+				}
+				if got := d.GetCounter().GetValue(); got != want {
+					t.Errorf("Corruption detected{kind=%q} = %v, want %v", kind, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPeakSurvivesDecrementAndReload(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	cfg := newPersistenceConfig(t)
+
+	c := NewCounter(0)
+	for i := 0; i < 5; i++ {
+		c.Increment()
+	}
+	c.Set(2)
+	if c.Peak() != 5 {
+		t.Fatalf("Peak = %d after setting 2, want 5", c.Peak())
+	}
+
+	if err := SaveCounter(context.Background(), c, cfg, clock.Real{}, &logger, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCounter(cfg, &logger, m)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.GetValue() != 2 || loaded.Peak() != 5 {
+		t.Errorf("Loaded %d (peak %d), want 2 (peak 5)", loaded.GetValue(), loaded.Peak())
+	}
+}
+
+func TestSaveCounterUTCTimestamps(t *testing.T) {
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC+5", 5*60*60)))
+
+	for _, tc := range []struct {
+		utc  bool
+		want string
+	}{
+		{true, "2025-01-01T07:00:00Z"},
+		{false, "2025-01-01T12:00:00+05:00"},
+	} {
+		cfg := newPersistenceConfig(t)
+		cfg.UTCTimestamps = tc.utc
+		if err := SaveCounter(context.Background(), NewCounter(1), cfg, clk, &logger, m); err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := os.ReadFile(cfg.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file struct {
+			LastUpdated string `json:"last_updated"`
+		}
+		if err := json.Unmarshal(raw, &file); err != nil {
+			t.Fatal(err)
+		}
+		if file.LastUpdated != tc.want {
+			t.Errorf("With UTCTimestamps %v the file holds %q, want %q", tc.utc, file.LastUpdated, tc.want)
+		}
+	}
+}
+
+// delayRecorder is a fake clock that records each AfterFunc delay and lets
+// it elapse straight away, so retry sleeps take no real time
+type delayRecorder struct {
+	*clock.Fake
+	delays []time.Duration
+}
+
+func (r *delayRecorder) AfterFunc(d time.Duration, fn func()) clock.Timer {
+	r.delays = append(r.delays, d)
+	timer := r.Fake.AfterFunc(d, fn)
+	r.Fake.Advance(d)
+	return timer
+}
+
+func TestSaveCounterRetryDelays(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	cfg.SaveRetryAttempts = 6
+	cfg.SaveRetryDelay = 100 * time.Millisecond
+	cfg.SaveRetryMaxDelay = time.Second
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	// Every attempt fails: the counter directory is a file
+	dir := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Filename = filepath.Join(dir, "counter.json")
+
+	for run := 0; run < 20; run++ {
+		clk := &delayRecorder{Fake: clock.NewFake(time.Now())}
+		c := NewCounter(0)
+		c.Increment()
+		if err := SaveCounter(context.Background(), c, cfg, clk, &logger, m); err == nil {
+			t.Fatal("Save into a file succeeded")
+		}
+
+		// Doubling from the base up to the cap, jittered over the upper half
+		caps := []time.Duration{100, 200, 400, 800, 1000}
+		if len(clk.delays) != len(caps) {
+			t.Fatalf("Slept %d times between 6 attempts, want 5", len(clk.delays))
+		}
+		for i, delay := range clk.delays {
+			upper := caps[i] * time.Millisecond
+			if delay < upper/2 || delay > upper {
+				t.Errorf("Retry %d slept %v, want between %v and %v", i+1, delay, upper/2, upper)
+			}
+		}
+	}
+}
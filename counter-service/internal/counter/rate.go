@@ -0,0 +1,74 @@
+package counter
+
+import (
+	"sync"
+	"time"
+)
+
+// rateTracker measures increments per second over a sliding window made of
+// one-second buckets in a ring buffer
+type rateTracker struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	buckets []int64
+	lastSec int64 // Unix second of the most recent bucket
+}
+
+// newRateTracker creates a tracker averaging over window, rounded down to
+// whole seconds and at least one second
+func newRateTracker(window time.Duration, now func() time.Time) *rateTracker {
+	size := int(window / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	return &rateTracker{
+		now:     now,
+		buckets: make([]int64, size),
+		lastSec: now().Unix(),
+	}
+}
+
+// record adds n increments to the current second
+func (rt *rateTracker) record(n int64) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	sec := rt.advance()
+	rt.buckets[sec%int64(len(rt.buckets))] += n
+}
+
+// rate returns the average increments per second over the window
+func (rt *rateTracker) rate() float64 {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.advance()
+	var total int64
+	for _, n := range rt.buckets {
+		total += n
+	}
+	return float64(total) / float64(len(rt.buckets))
+}
+
+// advance clears buckets for seconds that passed since the last call and
+// returns the current second. The caller must hold mu.
+func (rt *rateTracker) advance() int64 {
+	sec := rt.now().Unix()
+	if sec <= rt.lastSec {
+		// Clock went backwards or no time passed, keep using the latest bucket
+		return rt.lastSec
+	}
+
+	size := int64(len(rt.buckets))
+	if sec-rt.lastSec >= size {
+		for i := range rt.buckets {
+			rt.buckets[i] = 0
+		}
+	} else {
+		for s := rt.lastSec + 1; s <= sec; s++ {
+			rt.buckets[s%size] = 0
+		}
+	}
+	rt.lastSec = sec
+	return sec
+}
@@ -0,0 +1,31 @@
+package counter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateTrackerOverFakeClock(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rt := newRateTracker(10*time.Second, func() time.Time { return now })
+
+	rt.record(5)
+	now = now.Add(time.Second)
+	rt.record(15)
+
+	// 20 increments averaged over the 10 second window
+	if got := rt.rate(); got != 2 {
+		t.Errorf("Rate = %v, want 2", got)
+	}
+
+	// The first second's increments drop out once it leaves the window
+	now = now.Add(9 * time.Second)
+	if got := rt.rate(); got != 1.5 {
+		t.Errorf("Rate 10s after the first burst = %v, want 1.5", got)
+	}
+
+	now = now.Add(time.Second)
+	if got := rt.rate(); got != 0 {
+		t.Errorf("Rate once the window has passed = %v, want 0", got)
+	}
+}
@@ -0,0 +1,91 @@
+package counter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+// selfTestValue is the value the self test saves and expects back. It does
+// not fit in 32 bits, so truncation on the way through is caught too.
+const selfTestValue int64 = 1<<32 + 42
+
+// SelfTestStep is the outcome of one step of a self test
+type SelfTestStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// SelfTest saves a known value to a temporary counter file in dir through
+// SaveCounter, reads it back with LoadCounter and checks its CRC, using the
+// locking, format and fsync settings of cfg. It catches environments where
+// saves cannot work, such as a read-only directory or a filesystem without
+// flock, before the server takes traffic. The temporary file is removed
+// afterwards.
+//
+// The steps run so far are returned along with the error of the first one
+// that failed.
+func SelfTest(ctx context.Context, cfg *config.Config, dir string, logger *zerolog.Logger, metrics *metrics.Metrics) ([]SelfTestStep, error) {
+	tmpDir, err := os.MkdirTemp(dir, "counter-selftest-")
+	if err != nil {
+		return nil, &PersistError{Op: "selftest", Path: dir, Err: err}
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// One attempt is enough to tell whether saves work here
+	testCfg := *cfg
+	testCfg.Filename = filepath.Join(tmpDir, "counter.json")
+	testCfg.SaveRetryAttempts = 1
+
+	var steps []SelfTestStep
+	run := func(name string, fn func() error) error {
+		start := time.Now()
+		err := fn()
+		steps = append(steps, SelfTestStep{Name: name, Duration: time.Since(start), Err: err})
+		return err
+	}
+
+	saved := NewCounter(0)
+	saved.Set(selfTestValue)
+	if err := run("save", func() error {
+		return SaveCounter(ctx, saved, &testCfg, clock.Real{}, logger, metrics)
+	}); err != nil {
+		return steps, err
+	}
+
+	if err := run("load", func() error {
+		loaded, err := LoadCounter(&testCfg, logger, metrics)
+		if err != nil {
+			return err
+		}
+		if loaded.GetValue() != selfTestValue {
+			return fmt.Errorf("loaded %d, saved %d", loaded.GetValue(), selfTestValue)
+		}
+		return nil
+	}); err != nil {
+		return steps, err
+	}
+
+	if err := run("verify", func() error {
+		valid, err := verifyCounterFile(testCfg.Filename)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return &PersistError{Op: "verify", Path: testCfg.Filename, Err: ErrChecksumMismatch}
+		}
+		return nil
+	}); err != nil {
+		return steps, err
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,65 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+)
+
+func TestSelfTest(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	dir := t.TempDir()
+
+	steps, err := SelfTest(context.Background(), cfg, dir, &logger, m)
+	if err != nil {
+		t.Fatalf("SelfTest on a writable directory failed: %v", err)
+	}
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+		if step.Err != nil {
+			t.Errorf("Step %s failed: %v", step.Name, step.Err)
+		}
+	}
+	if got := strings.Join(names, ","); got != "save,load,verify" {
+		t.Errorf("Steps = %v, want save, load and verify", names)
+	}
+
+	// The temporary counter file is cleaned up
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("Directory holds %d entries after the self test, %v; want none", len(entries), err)
+	}
+}
+
+func TestSelfTestReadOnlyDirectory(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+
+	// Permission bits do not stop root, so as root the directory is a file
+	dir := filepath.Join(t.TempDir(), "readonly")
+	if os.Geteuid() == 0 {
+		if err := os.WriteFile(dir, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		if err := os.Mkdir(dir, 0555); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := SelfTest(context.Background(), cfg, dir, &logger, m)
+	var persistErr *PersistError
+	if !errors.As(err, &persistErr) || persistErr.Op != "selftest" || persistErr.Path != dir {
+		t.Errorf("SelfTest error = %v, want a selftest PersistError for %s", err, dir)
+	}
+}
@@ -1,74 +1,441 @@
 package counter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+	"github.com/yourusername/counter-service/pkg/logging"
 )
 
+// ErrNotDurable is returned with the new value when an increment succeeded
+// but could not be saved in write-through mode. The increment stands and is
+// saved with the next successful save; it wraps the save error.
+var ErrNotDurable = errors.New("increment applied but not saved")
+
+// ErrSaveDeferred is wrapped in ErrNotDurable when a write-through save is
+// skipped because persistence is degraded and backing off
+var ErrSaveDeferred = errors.New("persistence degraded, save deferred")
+
 // Service handles business logic for the counter
 type Service struct {
 	counter        *Counter
+	store          Store
 	config         *config.Config
+	clock          clock.Clock
 	logger         *zerolog.Logger
 	metrics        *metrics.Metrics
 	persistMu      sync.Mutex
+	persistCh      chan struct{}
+	debounceMu     sync.Mutex
+	debounceTimer  clock.Timer
+	debouncing     bool
+	persistTrigger *zerolog.Logger // logger of the request that asked for the pending save
 	shutdownCh     chan struct{}
 	backgroundDone chan struct{}
+	idempotency    *idempotencyCache
+	rate           *rateTracker
+	startTime      time.Time
+	readOnly       bool
+	ownerLock      *ownerLock
+
+	statusMu            sync.RWMutex
+	lastPersistTime     time.Time
+	lastPersistErr      error
+	consecutiveFailures int
+	nextPersistAttempt  time.Time // background saves wait until then while degraded
+}
+
+// PersistStatus describes the outcome of the most recent save attempts
+type PersistStatus struct {
+	// LastPersistTime is when the counter was last saved successfully,
+	// zero if it has not been saved since startup
+	LastPersistTime time.Time
+
+	// Age is how long ago the counter was last saved, zero if it has not
+	// been saved since startup
+	Age time.Duration
+
+	// LastPersistErr is the error from the latest attempt, nil if it succeeded
+	LastPersistErr error
+
+	// Dirty reports whether there are increments not yet on disk
+	Dirty bool
+
+	// ConsecutiveFailures is the number of save attempts that have failed
+	// since the last successful one
+	ConsecutiveFailures int
+
+	// Degraded reports whether background and write-through saves are
+	// backed off because cfg.DegradeAfterFailures saves in a row have failed
+	Degraded bool
+
+	// ReadOnly reports whether saving is disabled because the counter file
+	// could not be written at startup
+	ReadOnly bool
 }
 
 // NewService creates a new counter service
 func NewService(cfg *config.Config, logger *zerolog.Logger, metrics *metrics.Metrics) (*Service, error) {
-	// Load counter from disk
-	counter, err := LoadCounter(cfg, logger, metrics)
+	return NewServiceWithClock(cfg, clock.Real{}, logger, metrics)
+}
+
+// NewServiceWithClock creates a new counter service that reads time and
+// schedules background work through clk
+func NewServiceWithClock(cfg *config.Config, clk clock.Clock, logger *zerolog.Logger, metrics *metrics.Metrics) (*Service, error) {
+	// Load counter from the configured store
+	store := newStore(cfg, clk, logger, metrics)
+	counter, err := store.Load()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load counter: %w", err)
 	}
 
-	// Update metric for current counter value
+	if cfg.MaxValue > 0 {
+		counter.SetMaxValue(cfg.MaxValue)
+	}
+
+	// Find out now rather than on the first background save if the counter
+	// can never be written
+	readOnly := false
+	if !store.Persistent() {
+		logger.Info().Msg("Using in-memory storage; the counter is not saved")
+	} else if err := checkWritable(cfg); err != nil {
+		if !cfg.ReadOnlyFallback {
+			return nil, &PersistError{Op: "probe", Path: cfg.Filename, Err: fmt.Errorf("counter directory is not writable: %w", err)}
+		}
+		logger.Warn().Err(err).Msg("Counter directory is not writable, running read-only; increments will not be saved")
+		readOnly = true
+		metrics.ReadOnly.Set(1)
+	}
+
+	// Keep a second instance from writing the same file where flock is
+	// unreliable. A read-only instance never writes, so it needs no lock.
+	var owner *ownerLock
+	if cfg.OwnerLock && store.Persistent() && !readOnly {
+		owner, err = acquireOwnerLock(cfg.Filename, timestamp(cfg, clk.Now()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Update metrics for current counter value
 	metrics.CounterValue.Set(float64(counter.GetValue()))
+	metrics.PeakValue.Set(float64(counter.Peak()))
+	metrics.UnpersistedChanges.Set(float64(counter.UnsavedChanges()))
 
 	// Create service
 	service := &Service{
 		counter:        counter,
+		store:          store,
 		config:         cfg,
+		clock:          clk,
 		logger:         logger,
 		metrics:        metrics,
+		persistCh:      make(chan struct{}, 1),
 		shutdownCh:     make(chan struct{}),
 		backgroundDone: make(chan struct{}),
+		rate:           newRateTracker(cfg.IncrementRateWindow, clk.Now),
+		startTime:      timestamp(cfg, clk.Now()),
+		readOnly:       readOnly,
+		ownerLock:      owner,
 	}
+	metrics.StartTime.Set(float64(service.startTime.UnixNano()) / float64(time.Second))
 
-	// Start background persistence
-	go service.backgroundPersistence()
+	// Remember increment results for retried requests
+	if cfg.IdempotencyCacheSize > 0 {
+		service.idempotency = newIdempotencyCache(cfg.IdempotencyCacheSize, cfg.IdempotencyTTL, clk.Now)
+	}
+
+	// Start background persistence. Tickers are created before the
+	// goroutines start so a fake clock sees them straight away. Without an
+	// interval increments are saved as they happen, so there is no ticker.
+	// A memory store has nothing to save in the background.
+	if store.Persistent() {
+		var persistTicker clock.Ticker
+		if !service.writeThrough() {
+			persistTicker = clk.NewTicker(cfg.PersistInterval)
+		}
+		go service.backgroundPersistence(persistTicker)
+	} else {
+		close(service.backgroundDone)
+	}
+
+	// Keep the rate and lag gauges current even when no increments arrive
+	go service.publishGauges(clk.NewTicker(time.Second))
 
 	return service, nil
 }
 
-// Increment increments the counter and returns the new value
-func (s *Service) Increment() (int64, error) {
+// Increment increments the counter and returns the new value.
+// It returns the context error without incrementing if ctx is already done.
+// In write-through mode a failed save of the new value is returned wrapped
+// in ErrNotDurable; the increment has still happened.
+func (s *Service) Increment(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "Service.Increment")
+	defer span.End()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	// Increment counter
-	newValue := s.counter.Increment()
+	newValue, err := s.counter.Increment()
+	if err != nil {
+		s.metrics.CounterOperations.WithLabelValues("increment_overflow").Inc()
+		return newValue, err
+	}
 
-	// Update metric
+	// Update metrics
 	s.metrics.CounterValue.Set(float64(newValue))
+	s.metrics.PeakValue.Set(float64(s.counter.Peak()))
+	s.metrics.UnpersistedChanges.Set(float64(s.counter.UnsavedChanges()))
 	s.metrics.CounterOperations.WithLabelValues("increment").Inc()
+	s.rate.record(1)
+
+	return newValue, s.afterIncrement(ctx)
+}
+
+// DryRunIncrement returns the value Increment would return, without
+// changing or saving the counter. It fails with ErrCounterOverflow where
+// Increment would. Dry runs are counted under their own operation label so
+// they stay out of the increment totals.
+func (s *Service) DryRunIncrement(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.metrics.CounterOperations.WithLabelValues("increment_dry_run").Inc()
+	value := s.counter.GetValue()
+	if value >= s.counter.maxValue {
+		return value, ErrCounterOverflow
+	}
+	return value + 1, nil
+}
+
+// CompareAndIncrement increments the counter only if it currently equals
+// expected, returning the new value. On ErrValueMismatch the returned value
+// is the actual current value. Save errors are returned wrapped in
+// ErrNotDurable as for Increment.
+func (s *Service) CompareAndIncrement(ctx context.Context, expected int64) (int64, error) {
+	ctx, span := tracer.Start(ctx, "Service.CompareAndIncrement")
+	defer span.End()
 
-	return newValue, nil
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	newValue, ok := s.counter.CompareAndIncrement(expected)
+	if !ok {
+		if newValue == expected {
+			s.metrics.CounterOperations.WithLabelValues("increment_overflow").Inc()
+			return newValue, ErrCounterOverflow
+		}
+		s.metrics.CounterOperations.WithLabelValues("compare_and_increment_mismatch").Inc()
+		return newValue, ErrValueMismatch
+	}
+
+	// Update metrics
+	s.metrics.CounterValue.Set(float64(newValue))
+	s.metrics.PeakValue.Set(float64(s.counter.Peak()))
+	s.metrics.UnpersistedChanges.Set(float64(s.counter.UnsavedChanges()))
+	s.metrics.CounterOperations.WithLabelValues("compare_and_increment").Inc()
+	s.rate.record(1)
+
+	return newValue, s.afterIncrement(ctx)
+}
+
+// IncrementIdempotent increments the counter once per key. A key seen again
+// within the idempotency TTL returns the value from the first call with
+// replayed set, without incrementing. An empty key, or a disabled cache,
+// behaves like Increment. Increments that were applied but not saved are
+// recorded too, so a retry after ErrNotDurable does not increment again.
+func (s *Service) IncrementIdempotent(ctx context.Context, key string) (value int64, replayed bool, err error) {
+	if key == "" || s.idempotency == nil {
+		value, err = s.Increment(ctx)
+		return value, false, err
+	}
+
+	value, replayed, err = s.idempotency.do(ctx, key, func() (int64, error) {
+		return s.Increment(ctx)
+	})
+	if replayed {
+		s.metrics.CounterOperations.WithLabelValues("increment_replayed").Inc()
+	}
+	return value, replayed, err
+}
+
+// afterIncrement saves the counter straight away in write-through mode,
+// returning a failed save wrapped in ErrNotDurable, and otherwise asks for
+// an early save once enough increments have accumulated. Memory stores need
+// neither.
+func (s *Service) afterIncrement(ctx context.Context) error {
+	if !s.store.Persistent() {
+		return nil
+	}
+	if s.writeThrough() && !s.readOnly {
+		// While degraded, saves wait out the backoff like background saves
+		// rather than hitting a failing disk on every increment
+		if s.backingOff() {
+			return fmt.Errorf("%w: %w", ErrNotDurable, ErrSaveDeferred)
+		}
+		if err := s.Persist(ctx); err != nil {
+			return fmt.Errorf("%w: %w", ErrNotDurable, err)
+		}
+		return nil
+	}
+	if s.config.PersistEvery > 0 && s.counter.UnsavedChanges() >= int64(s.config.PersistEvery) {
+		s.requestPersist(ctx)
+	}
+	return nil
+}
+
+// writeThrough reports whether every increment is saved before it returns,
+// which is the case when PersistInterval is zero or negative
+func (s *Service) writeThrough() bool {
+	return s.config.PersistInterval <= 0
 }
 
 // GetValue returns the current counter value
-func (s *Service) GetValue() (int64, error) {
+func (s *Service) GetValue(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	value := s.counter.GetValue()
 	s.metrics.CounterOperations.WithLabelValues("get").Inc()
 	return value, nil
 }
 
-// Persist forces the counter to be persisted to disk
-func (s *Service) Persist() error {
+// Peak returns the highest value the counter has held, including values
+// from earlier runs that were saved
+func (s *Service) Peak() int64 {
+	return s.counter.Peak()
+}
+
+// Now returns the current time from the service clock, in UTC when
+// cfg.UTCTimestamps is set
+func (s *Service) Now() time.Time {
+	return timestamp(s.config, s.clock.Now())
+}
+
+// StartTime returns when the service was created
+func (s *Service) StartTime() time.Time {
+	return s.startTime
+}
+
+// Uptime returns how long the service has been running
+func (s *Service) Uptime() time.Duration {
+	return s.clock.Now().Sub(s.startTime)
+}
+
+// IncrementRate returns the average increments per second over the
+// configured rate window
+func (s *Service) IncrementRate() float64 {
+	return s.rate.rate()
+}
+
+// publishGauges updates the increment rate and persist lag gauges on every
+// tick until the service shuts down
+func (s *Service) publishGauges(ticker clock.Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.metrics.IncrementsPerSecond.Set(s.rate.rate())
+			s.metrics.PersistLag.Set(s.persistLag().Seconds())
+		case <-s.shutdownCh:
+			return
+		}
+	}
+}
+
+// Verify reports whether the counter file on disk passes its CRC check
+func (s *Service) Verify(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.metrics.CounterOperations.WithLabelValues("verify").Inc()
+	return s.store.Verify()
+}
+
+// Warmup exercises the persistence path the way traffic will: it reads the
+// counter file under a shared lock, warming the page cache, and proves the
+// directory still accepts writes. A file that has not been written yet is
+// not an error. Read-only services fail with ErrReadOnly, and memory stores
+// have nothing to check.
+func (s *Service) Warmup(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.metrics.CounterOperations.WithLabelValues("warmup").Inc()
+	if !s.store.Persistent() {
+		return nil
+	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	if _, err := ReadCounterFile(s.config); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := checkWritable(s.config); err != nil {
+		return &PersistError{Op: "probe", Path: s.config.Filename, Err: err}
+	}
+	return nil
+}
+
+// Export returns the current counter state in its persisted form
+func (s *Service) Export(ctx context.Context) (CounterData, error) {
+	if err := ctx.Err(); err != nil {
+		return CounterData{}, err
+	}
+
+	s.metrics.CounterOperations.WithLabelValues("export").Inc()
+	return newCounterData(s.counter.GetValue(), s.counter.Peak(), s.Now())
+}
+
+// Import replaces the counter with previously exported state and persists it
+// immediately. The peak is raised to the imported peak but never lowered.
+// Data with a bad CRC or from another version is rejected.
+func (s *Service) Import(ctx context.Context, data CounterData) error {
+	if err := data.Verify(); err != nil {
+		return err
+	}
+	if s.config.MaxValue > 0 && data.Visits > s.config.MaxValue {
+		return ErrCounterOverflow
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.counter.Set(data.Visits)
+	s.counter.raisePeak(data.Peak)
+	s.metrics.CounterValue.Set(float64(data.Visits))
+	s.metrics.PeakValue.Set(float64(s.counter.Peak()))
+	s.metrics.UnpersistedChanges.Set(float64(s.counter.UnsavedChanges()))
+	s.metrics.CounterOperations.WithLabelValues("import").Inc()
+	logging.FromContext(ctx, s.logger).Info().Int64("visits", data.Visits).Msg("Counter state imported")
+
+	return s.Persist(ctx)
+}
+
+// Persist forces the counter to be persisted to disk. It saves even while
+// degraded persistence is backing off, as shutdown and explicit flushes
+// should.
+func (s *Service) Persist(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "Service.Persist")
+	defer span.End()
+
 	s.persistMu.Lock()
 	defer s.persistMu.Unlock()
 
@@ -76,30 +443,160 @@ func (s *Service) Persist() error {
 	if !s.counter.IsDirty() {
 		return nil
 	}
+	if s.readOnly {
+		return ErrReadOnly
+	}
 
-	s.logger.Debug().Msg("Persisting counter to disk")
-	return SaveCounter(s.counter, s.config, s.logger, s.metrics)
+	logger := logging.FromContext(ctx, s.logger)
+	logger.Debug().Msg("Persisting counter to disk")
+	err := s.store.Save(ctx, s.counter, logger)
+	s.recordPersist(err)
+	return err
 }
 
-// backgroundPersistence periodically saves the counter to disk
-func (s *Service) backgroundPersistence() {
-	ticker := time.NewTicker(s.config.PersistInterval)
-	defer ticker.Stop()
+// requestPersist asks the background loop to save after the PersistDebounce
+// quiet period. Requests made while one is already pending are coalesced into
+// a single save, bounding write amplification during bursts.
+//
+// The logger in ctx is kept for the save, so its logs carry the ID of the
+// first request that asked for it.
+//
+// The timer has its own mutex rather than persistMu so increments never wait
+// on a save in progress.
+func (s *Service) requestPersist(ctx context.Context) {
+	s.debounceMu.Lock()
+	defer s.debounceMu.Unlock()
+
+	if s.persistTrigger == nil {
+		s.persistTrigger = logging.FromContext(ctx, nil)
+	}
+
+	if s.config.PersistDebounce <= 0 {
+		s.signalPersist()
+		return
+	}
+
+	// A save is already scheduled and will include this increment
+	if s.debouncing {
+		return
+	}
+	s.debouncing = true
+
+	if s.debounceTimer == nil {
+		s.debounceTimer = s.clock.AfterFunc(s.config.PersistDebounce, s.debounceFired)
+	} else {
+		s.debounceTimer.Reset(s.config.PersistDebounce)
+	}
+}
+
+// debounceFired hands the pending save to the background loop
+func (s *Service) debounceFired() {
+	s.debounceMu.Lock()
+	s.debouncing = false
+	s.debounceMu.Unlock()
+
+	s.signalPersist()
+}
+
+// signalPersist wakes the background loop without blocking
+func (s *Service) signalPersist() {
+	select {
+	case s.persistCh <- struct{}{}:
+	default:
+	}
+}
+
+// Delays before relaunching the persistence loop after a panic, doubling
+// for each panic in quick succession
+const (
+	minPersistRestartDelay = time.Second
+	maxPersistRestartDelay = time.Minute
+)
+
+// backgroundSaveHook runs at the start of every background save; tests
+// replace it to make the persistence loop panic
+var backgroundSaveHook = func() {}
+
+// backgroundPersistence runs the persistence loop until shutdown. If the
+// loop panics, it is relaunched after a backoff delay so saves do not stop
+// silently. ticker is nil in write-through mode.
+func (s *Service) backgroundPersistence(ticker clock.Ticker) {
+	if ticker != nil {
+		defer ticker.Stop()
+	}
 	defer close(s.backgroundDone)
 
+	// Derive a context that is cancelled on shutdown so an in-flight save
+	// stops retrying once the service is stopping
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
 	s.logger.Debug().Dur("interval", s.config.PersistInterval).Msg("Starting background persistence")
 
+	delay := minPersistRestartDelay
 	for {
+		started := s.clock.Now()
+		if !s.runPersistenceLoop(ctx, ticker) {
+			return
+		}
+		s.metrics.BackgroundRestarts.Inc()
+
+		// A loop that stayed up for a while gets a fresh backoff
+		if s.clock.Now().Sub(started) > maxPersistRestartDelay {
+			delay = minPersistRestartDelay
+		}
+		s.logger.Error().Dur("delay", delay).Msg("Background persistence panicked, restarting")
+
+		restart := make(chan struct{})
+		timer := s.clock.AfterFunc(delay, func() { close(restart) })
 		select {
-		case <-ticker.C:
-			if s.counter.IsDirty() {
-				s.logger.Debug().Msg("Performing scheduled counter persistence")
-				s.persistMu.Lock()
-				if err := SaveCounter(s.counter, s.config, s.logger, s.metrics); err != nil {
-					s.logger.Error().Err(err).Msg("Failed to persist counter in background")
-				}
-				s.persistMu.Unlock()
-			}
+		case <-restart:
+		case <-s.shutdownCh:
+			timer.Stop()
+			return
+		}
+
+		delay *= 2
+		if delay > maxPersistRestartDelay {
+			delay = maxPersistRestartDelay
+		}
+	}
+}
+
+// runPersistenceLoop saves the counter on each tick and persist request
+// until shutdown, reporting whether it stopped because of a panic
+func (s *Service) runPersistenceLoop(ctx context.Context, ticker clock.Ticker) (panicked bool) {
+	panicked = true
+	defer logging.RecoveryFn(s.logger)()
+
+	s.persistenceLoop(ctx, ticker)
+	return false
+}
+
+// persistenceLoop periodically saves the counter to disk, and early when
+// requested after PersistEvery increments. A nil ticker disables the
+// periodic saves.
+func (s *Service) persistenceLoop(ctx context.Context, ticker clock.Ticker) {
+	var tick <-chan time.Time
+	if ticker != nil {
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-tick:
+			s.logger.Debug().Msg("Performing scheduled counter persistence")
+			s.persistInBackground(ctx)
+		case <-s.persistCh:
+			s.logger.Debug().Msg("Performing threshold-triggered counter persistence")
+			s.persistInBackground(ctx)
 		case <-s.shutdownCh:
 			s.logger.Debug().Msg("Background persistence stopping")
 			return
@@ -107,9 +604,179 @@ func (s *Service) backgroundPersistence() {
 	}
 }
 
-// Shutdown stops the background persistence
+// persistInBackground saves the counter if it has unsaved changes. A counter
+// that is dirty but back at its saved value is marked clean without a write.
+// It logs with the logger of the request that asked for the save, if any.
+func (s *Service) persistInBackground(ctx context.Context) {
+	backgroundSaveHook()
+
+	s.debounceMu.Lock()
+	logger := s.persistTrigger
+	s.persistTrigger = nil
+	s.debounceMu.Unlock()
+	if logger == nil {
+		logger = s.logger
+	}
+
+	if !s.counter.IsDirty() || s.readOnly {
+		return
+	}
+	if s.backingOff() {
+		logger.Debug().Msg("Persistence degraded, deferring background save")
+		return
+	}
+
+	s.persistMu.Lock()
+	defer s.persistMu.Unlock()
+
+	// Changes that cancelled out, such as importing the saved value, leave
+	// nothing new to write
+	if value := s.counter.GetValue(); s.counter.UnsavedChanges() == 0 {
+		s.counter.MarkClean(value)
+		s.metrics.UnpersistedChanges.Set(0)
+		s.metrics.CounterOperations.WithLabelValues("save_skipped").Inc()
+		logger.Debug().Int64("visits", value).Msg("Counter unchanged since last save, skipping write")
+		return
+	}
+
+	err := s.store.Save(ctx, s.counter, logger)
+	s.recordPersist(err)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to persist counter in background")
+	}
+}
+
+// recordPersist stores the outcome of a save attempt. After
+// cfg.DegradeAfterFailures failures in a row persistence is degraded until a
+// save succeeds.
+func (s *Service) recordPersist(err error) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	s.lastPersistErr = err
+	if err == nil {
+		s.lastPersistTime = s.Now()
+		s.metrics.LastPersistTimestamp.Set(float64(s.lastPersistTime.UnixNano()) / 1e9)
+		s.metrics.PersistLag.Set(0)
+		if s.degraded() {
+			s.logger.Info().Int("failures", s.consecutiveFailures).Msg("Counter saved, persistence recovered")
+			s.metrics.Degraded.Set(0)
+		}
+		s.consecutiveFailures = 0
+	} else {
+		s.consecutiveFailures++
+		if s.degraded() {
+			delay := s.degradedRetryDelay()
+			s.nextPersistAttempt = s.clock.Now().Add(delay)
+			if s.consecutiveFailures == s.config.DegradeAfterFailures {
+				s.logger.Warn().
+					Err(err).
+					Int("failures", s.consecutiveFailures).
+					Dur("retryIn", delay).
+					Msg("Counter saves keep failing, persistence degraded; backing off saves")
+				s.metrics.Degraded.Set(1)
+			}
+		}
+	}
+	s.metrics.UnpersistedChanges.Set(float64(s.counter.UnsavedChanges()))
+}
+
+// degraded reports whether enough saves in a row have failed to back off.
+// statusMu must be held.
+func (s *Service) degraded() bool {
+	return s.config.DegradeAfterFailures > 0 && s.consecutiveFailures >= s.config.DegradeAfterFailures
+}
+
+// minDegradedRetryDelay is the first backoff in write-through mode, which
+// has no persist interval to start from
+const minDegradedRetryDelay = time.Second
+
+// degradedRetryDelay returns how long saves wait after the latest failure:
+// the persist interval, or minDegradedRetryDelay in write-through mode,
+// doubling with each failure past the threshold up to
+// cfg.DegradedRetryMaxDelay. statusMu must be held.
+func (s *Service) degradedRetryDelay() time.Duration {
+	delay := s.config.PersistInterval
+	if s.writeThrough() {
+		delay = minDegradedRetryDelay
+	}
+	for i := s.config.DegradeAfterFailures; i < s.consecutiveFailures && delay < s.config.DegradedRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if s.config.DegradedRetryMaxDelay > 0 && delay > s.config.DegradedRetryMaxDelay {
+		delay = s.config.DegradedRetryMaxDelay
+	}
+	return delay
+}
+
+// backingOff reports whether persistence is degraded and the next
+// background or write-through save is not due yet
+func (s *Service) backingOff() bool {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+
+	return s.degraded() && s.clock.Now().Before(s.nextPersistAttempt)
+}
+
+// persistLag returns how long ago the counter was last saved, or how long
+// the service has been up if it has not been saved yet. It is zero for
+// stores that are never saved.
+func (s *Service) persistLag() time.Duration {
+	if !s.store.Persistent() {
+		return 0
+	}
+
+	s.statusMu.RLock()
+	since := s.lastPersistTime
+	s.statusMu.RUnlock()
+	if since.IsZero() {
+		since = s.startTime
+	}
+	return s.clock.Now().Sub(since)
+}
+
+// PersistStatus returns when the counter was last saved and whether the
+// latest save attempt failed
+func (s *Service) PersistStatus() PersistStatus {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+
+	status := PersistStatus{
+		LastPersistTime: s.lastPersistTime,
+		LastPersistErr:  s.lastPersistErr,
+		Dirty:           s.counter.IsDirty(),
+		ReadOnly:        s.readOnly,
+
+		ConsecutiveFailures: s.consecutiveFailures,
+		Degraded:            s.degraded(),
+	}
+	if !status.LastPersistTime.IsZero() {
+		status.Age = s.clock.Now().Sub(status.LastPersistTime)
+	}
+	return status
+}
+
+// Shutdown stops the background persistence, saves the counter and releases
+// the owner lock file
 func (s *Service) Shutdown() error {
+	s.debounceMu.Lock()
+	if s.debounceTimer != nil {
+		s.debounceTimer.Stop()
+	}
+	s.debounceMu.Unlock()
+
 	close(s.shutdownCh)
 	<-s.backgroundDone
-	return s.Persist()
-}
\ No newline at end of file
+	if s.readOnly {
+		s.logger.Warn().Int64("unsaved", s.counter.UnsavedChanges()).Msg("Read-only, discarding unsaved increments")
+		return nil
+	}
+
+	err := s.Persist(context.Background())
+	if s.ownerLock != nil {
+		if releaseErr := s.ownerLock.release(); releaseErr != nil {
+			s.logger.Warn().Err(releaseErr).Msg("Failed to remove owner lock file")
+		}
+	}
+	return err
+}
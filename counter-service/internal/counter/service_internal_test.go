@@ -0,0 +1,120 @@
+package counter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+func TestBackgroundPersistenceRecoversFromPanic(t *testing.T) {
+	var panics atomic.Int32
+	panics.Store(1)
+	saved := backgroundSaveHook
+	backgroundSaveHook = func() {
+		if panics.Add(-1) >= 0 {
+			panic("injected persistence failure")
+		}
+	}
+	t.Cleanup(func() { backgroundSaveHook = saved })
+
+	cfg := newPersistenceConfig(t)
+	cfg.PersistInterval = time.Minute
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	service, err := NewServiceWithClock(cfg, clk, &logger, m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	service.Increment(context.Background())
+
+	// The first tick panics; keep the clock moving so the loop is relaunched
+	// after its backoff and the next tick saves
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := ReadCounterFile(cfg); err == nil && data.Visits == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a save after the panic")
+		}
+		clk.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+
+	var d dto.Metric
+	if err := m.BackgroundRestarts.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Background restarts = %v, want 1", got)
+	}
+
+	// Shutdown still stops the relaunched loop
+	done := make(chan error, 1)
+	go func() { done <- service.Shutdown() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return after a relaunch")
+	}
+}
+
+func TestBackgroundPersistenceSkipsUnchangedValue(t *testing.T) {
+	cfg := newPersistenceConfig(t)
+	cfg.PersistInterval = time.Minute
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	logger := zerolog.Nop()
+	m := metrics.NewMetrics(&config.Config{})
+	service, err := NewServiceWithClock(cfg, clk, &logger, m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	t.Cleanup(func() { service.Shutdown() })
+
+	ctx := context.Background()
+	service.Increment(ctx)
+	if err := service.Persist(ctx); err != nil {
+		t.Fatal(err)
+	}
+	operations := func(op string) float64 {
+		var d dto.Metric
+		if err := m.CounterOperations.WithLabelValues(op).Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		return d.GetCounter().GetValue()
+	}
+	saves := operations("save")
+
+	// Up and back down to the saved value: dirty, but nothing to write
+	service.counter.Increment()
+	service.counter.Set(1)
+	if !service.counter.IsDirty() {
+		t.Fatal("Counter is clean after changes")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for operations("save_skipped") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the background save to be skipped")
+		}
+		clk.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+	if got := operations("save"); got != saves {
+		t.Errorf("%v saves after changes that cancelled out, want %v", got, saves)
+	}
+	if service.counter.IsDirty() {
+		t.Error("Counter is still dirty after the skipped save")
+	}
+}
@@ -0,0 +1,441 @@
+package counter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/counter"
+	"github.com/yourusername/counter-service/internal/test"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+// newService creates a counter service for cfg on a fake clock, shut down
+// when the test is done
+func newService(t *testing.T, cfg *config.Config) *counter.Service {
+	t.Helper()
+
+	return newServiceWithClock(t, cfg, test.NewTestClock())
+}
+
+// newServiceWithClock is newService driven by clk
+func newServiceWithClock(t *testing.T, cfg *config.Config, clk clock.Clock) *counter.Service {
+	t.Helper()
+
+	service, err := counter.NewServiceWithClock(cfg, clk, test.NewTestLogger(), test.NewTestMetrics())
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	t.Cleanup(func() {
+		service.Shutdown()
+	})
+	return service
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+// Background saves run on their own goroutine even with a fake clock.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// savedVisits returns the visits in the counter file, or -1 if it cannot be
+// read
+func savedVisits(cfg *config.Config) int64 {
+	data, err := counter.ReadCounterFile(cfg)
+	if err != nil {
+		return -1
+	}
+	return data.Visits
+}
+
+// breakCounterDir makes saves to cfg.Filename fail by replacing its
+// directory with a file. Tests run as root, so permissions cannot do it.
+func breakCounterDir(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	dir := filepath.Dir(cfg.Filename)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// repairCounterDir undoes breakCounterDir
+func repairCounterDir(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	dir := filepath.Dir(cfg.Filename)
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteThroughSavesEachIncrement(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = 0
+	service := newService(t, cfg)
+
+	for want := int64(1); want <= 5; want++ {
+		value, err := service.Increment(context.Background())
+		if err != nil {
+			t.Fatalf("Increment failed: %v", err)
+		}
+		if value != want {
+			t.Fatalf("Increment = %d, want %d", value, want)
+		}
+
+		data, err := counter.ReadCounterFile(cfg)
+		if err != nil {
+			t.Fatalf("Failed to read counter file: %v", err)
+		}
+		if data.Visits != want {
+			t.Fatalf("Saved visits = %d after increment %d, want it saved before Increment returns", data.Visits, want)
+		}
+	}
+
+	if service.PersistStatus().Dirty {
+		t.Error("Counter is dirty after write-through increments")
+	}
+}
+
+func TestWriteThroughSaveFailureKeepsIncrement(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = 0
+	service := newService(t, cfg)
+	breakCounterDir(t, cfg)
+
+	value, replayed, err := service.IncrementIdempotent(context.Background(), "retry-me")
+	if !errors.Is(err, counter.ErrNotDurable) {
+		t.Fatalf("IncrementIdempotent error = %v, want ErrNotDurable", err)
+	}
+	if value != 1 || replayed {
+		t.Fatalf("IncrementIdempotent = %d, replayed %v; want 1, not replayed", value, replayed)
+	}
+
+	// The client retries after the failure; the increment must not apply twice
+	value, replayed, err = service.IncrementIdempotent(context.Background(), "retry-me")
+	if err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if value != 1 || !replayed {
+		t.Errorf("Retry = %d, replayed %v; want 1, replayed", value, replayed)
+	}
+
+	current, _ := service.GetValue(context.Background())
+	if current != 1 {
+		t.Errorf("Counter = %d after a retried increment, want 1", current)
+	}
+	if !service.PersistStatus().Dirty {
+		t.Error("Counter is clean, want the unsaved increment kept")
+	}
+}
+
+func TestRepeatedSaveFailuresDegradeAndBackOff(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = 0
+	cfg.DegradeAfterFailures = 3
+	cfg.DegradedRetryMaxDelay = time.Minute
+	clk := test.NewTestClock()
+	service := newServiceWithClock(t, cfg, clk)
+	breakCounterDir(t, cfg)
+
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		if _, err := service.Increment(ctx); errors.Is(err, counter.ErrSaveDeferred) || !errors.Is(err, counter.ErrNotDurable) {
+			t.Fatalf("Increment %d error = %v, want a failed save", i, err)
+		}
+		if degraded := service.PersistStatus().Degraded; degraded != (i == 3) {
+			t.Fatalf("Degraded = %v after %d failures, want %v", degraded, i, i == 3)
+		}
+	}
+
+	// Backing off: increments do not touch the disk
+	if _, err := service.Increment(ctx); !errors.Is(err, counter.ErrSaveDeferred) {
+		t.Fatalf("Increment while backing off error = %v, want ErrSaveDeferred", err)
+	}
+	if got := service.PersistStatus().ConsecutiveFailures; got != 3 {
+		t.Fatalf("ConsecutiveFailures = %d while backing off, want 3", got)
+	}
+
+	// Once the first delay has passed the next increment tries again, and
+	// its failure doubles the delay
+	clk.Advance(time.Second)
+	if _, err := service.Increment(ctx); errors.Is(err, counter.ErrSaveDeferred) || !errors.Is(err, counter.ErrNotDurable) {
+		t.Fatalf("Increment after backoff error = %v, want a failed save", err)
+	}
+	if got := service.PersistStatus().ConsecutiveFailures; got != 4 {
+		t.Fatalf("ConsecutiveFailures = %d, want 4", got)
+	}
+	clk.Advance(time.Second)
+	if _, err := service.Increment(ctx); !errors.Is(err, counter.ErrSaveDeferred) {
+		t.Fatalf("Increment 1s into a 2s backoff error = %v, want ErrSaveDeferred", err)
+	}
+
+	// A save that succeeds ends the degraded state and writes every increment
+	repairCounterDir(t, cfg)
+	clk.Advance(time.Second)
+	value, err := service.Increment(ctx)
+	if err != nil {
+		t.Fatalf("Increment after repair failed: %v", err)
+	}
+	status := service.PersistStatus()
+	if status.Degraded || status.ConsecutiveFailures != 0 || status.Dirty {
+		t.Errorf("Status after recovery = %+v, want healthy and clean", status)
+	}
+	data, err := counter.ReadCounterFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to read counter file: %v", err)
+	}
+	if data.Visits != value {
+		t.Errorf("Saved visits = %d, want %d", data.Visits, value)
+	}
+}
+
+func TestPersistEverySavesBeforeInterval(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = time.Hour
+	cfg.PersistEvery = 5
+	cfg.PersistDebounce = 0
+	service := newService(t, cfg)
+
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		service.Increment(ctx)
+	}
+
+	// The fake clock never reaches the interval, so only the threshold saves
+	time.Sleep(20 * time.Millisecond)
+	if visits := savedVisits(cfg); visits != -1 {
+		t.Fatalf("Counter saved with %d visits below the threshold", visits)
+	}
+
+	service.Increment(ctx)
+	waitFor(t, "the threshold save", func() bool { return savedVisits(cfg) == 5 })
+}
+
+func TestPersistDebounceCoalescesBursts(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = time.Hour
+	cfg.PersistEvery = 1
+	cfg.PersistDebounce = 250 * time.Millisecond
+	clk := test.NewTestClock()
+	m := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, clk, test.NewTestLogger(), m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+
+	// Every increment in the burst is over the threshold
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		service.Increment(ctx)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if visits := savedVisits(cfg); visits != -1 {
+		t.Fatalf("Counter saved with %d visits during the debounce period", visits)
+	}
+
+	clk.Advance(cfg.PersistDebounce)
+	waitFor(t, "the debounced save", func() bool { return savedVisits(cfg) == 100 })
+
+	var d dto.Metric
+	if err := m.CounterOperations.WithLabelValues("save").Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetCounter().GetValue(); got != 1 {
+		t.Errorf("%v disk writes for a burst of 100 increments, want 1", got)
+	}
+}
+
+func TestPersistGauges(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = time.Hour
+	clk := test.NewTestClock()
+	m := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, clk, test.NewTestLogger(), m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+	gauge := func(g interface{ Write(*dto.Metric) error }) float64 {
+		var d dto.Metric
+		if err := g.Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		return d.GetGauge().GetValue()
+	}
+
+	// The interval is never reached, so nothing is saved
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		service.Increment(ctx)
+	}
+	if got := gauge(m.UnpersistedChanges); got != 3 {
+		t.Errorf("Unpersisted changes = %v after 3 increments, want 3", got)
+	}
+	clk.Advance(30 * time.Second)
+	waitFor(t, "the persist lag to reach 30s", func() bool { return gauge(m.PersistLag) == 30 })
+
+	if err := service.Persist(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if unsaved, lag := gauge(m.UnpersistedChanges), gauge(m.PersistLag); unsaved != 0 || lag != 0 {
+		t.Errorf("After a save unpersisted changes = %v, lag = %v; want 0, 0", unsaved, lag)
+	}
+	service.Increment(ctx)
+	clk.Advance(5 * time.Second)
+	waitFor(t, "the persist lag to restart from the save", func() bool { return gauge(m.PersistLag) == 5 })
+	if got := gauge(m.UnpersistedChanges); got != 1 {
+		t.Errorf("Unpersisted changes = %v after an increment since the save, want 1", got)
+	}
+}
+
+func TestUptime(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.PersistInterval = time.Hour
+	clk := test.NewTestClock()
+	m := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, clk, test.NewTestLogger(), m)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+	defer service.Shutdown()
+
+	if !service.StartTime().Equal(clk.Now()) || service.Uptime() != 0 {
+		t.Fatalf("Started at %v with uptime %v, want the clock time and 0", service.StartTime(), service.Uptime())
+	}
+
+	clk.Advance(90 * time.Second)
+	if got := service.Uptime(); got != 90*time.Second {
+		t.Errorf("Uptime = %v, want 1m30s", got)
+	}
+
+	var d dto.Metric
+	if err := m.StartTime.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetGauge().GetValue(); got != float64(service.StartTime().Unix()) {
+		t.Errorf("Start time metric = %v, want %d", got, service.StartTime().Unix())
+	}
+}
+
+// makeReadOnly stops cfg.Filename being written while it can still be read.
+// Permission bits do not stop root, so as root a directory is put where the
+// startup probe writes instead.
+func makeReadOnly(t *testing.T, cfg *config.Config) {
+	t.Helper()
+
+	if os.Geteuid() == 0 {
+		if err := os.MkdirAll(filepath.Join(cfg.Filename+".probe", "blocker"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	dir := filepath.Dir(cfg.Filename)
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+}
+
+func TestReadOnlyDirectoryFailsStartup(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	makeReadOnly(t, cfg)
+
+	_, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), test.NewTestLogger(), test.NewTestMetrics())
+	var persistErr *counter.PersistError
+	if !errors.As(err, &persistErr) || persistErr.Op != "probe" {
+		t.Fatalf("NewService error = %v, want the failed writability probe", err)
+	}
+}
+
+func TestReadOnlyDirectoryFallback(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.ReadOnlyFallback = true
+	makeReadOnly(t, cfg)
+	m := test.NewTestMetrics()
+	service, err := counter.NewServiceWithClock(cfg, test.NewTestClock(), test.NewTestLogger(), m)
+	if err != nil {
+		t.Fatalf("NewService with the read-only fallback failed: %v", err)
+	}
+	defer service.Shutdown()
+
+	if !service.PersistStatus().ReadOnly {
+		t.Error("Persist status does not report read-only")
+	}
+	var d dto.Metric
+	if err := m.ReadOnly.Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetGauge().GetValue(); got != 1 {
+		t.Errorf("Read-only gauge = %v, want 1", got)
+	}
+
+	// Increments still count in memory, but saves are refused
+	ctx := context.Background()
+	if visits, err := service.Increment(ctx); err != nil || visits != 1 {
+		t.Errorf("Increment = %d, %v; want 1, nil", visits, err)
+	}
+	if err := service.Persist(ctx); !errors.Is(err, counter.ErrReadOnly) {
+		t.Errorf("Persist = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestMemoryStoreCreatesNoFiles(t *testing.T) {
+	cfg := test.NewTestConfig(t)
+	cfg.StorageBackend = counter.StorageMemory
+	cfg.InitialValue = 10
+	cfg.PersistInterval = time.Second
+	clk := test.NewTestClock()
+	service, err := counter.NewServiceWithClock(cfg, clk, test.NewTestLogger(), test.NewTestMetrics())
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := service.Increment(ctx); err != nil {
+			t.Fatalf("Increment failed: %v", err)
+		}
+	}
+	if visits, _ := service.GetValue(ctx); visits != 13 {
+		t.Errorf("Counter = %d, want 13", visits)
+	}
+
+	// Neither explicit nor scheduled saves touch the disk
+	if err := service.Persist(ctx); err != nil {
+		t.Errorf("Persist = %v, want nil", err)
+	}
+	clk.Advance(time.Minute)
+	if err := service.Shutdown(); err != nil {
+		t.Errorf("Shutdown = %v, want nil", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(cfg.Filename))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Memory store created %s", entry.Name())
+	}
+}
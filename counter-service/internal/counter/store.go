@@ -0,0 +1,100 @@
+package counter
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/config"
+	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
+)
+
+// Storage backends selectable with cfg.StorageBackend
+const (
+	StorageFile   = "file"
+	StorageMemory = "memory"
+)
+
+// Store loads and saves the counter value for a Service
+type Store interface {
+	// Load returns the stored counter, or a new one if nothing is stored
+	Load() (*Counter, error)
+
+	// Save stores the current value of counter and marks it clean
+	Save(ctx context.Context, counter *Counter, logger *zerolog.Logger) error
+
+	// Verify reports whether the stored data passes its integrity check
+	Verify() (bool, error)
+
+	// Persistent reports whether saved values outlive the process. Services
+	// skip the disk checks and background saves for stores that do not.
+	Persistent() bool
+}
+
+// newStore returns the store selected by cfg.StorageBackend
+func newStore(cfg *config.Config, clk clock.Clock, logger *zerolog.Logger, metrics *metrics.Metrics) Store {
+	if cfg.StorageBackend == StorageMemory {
+		return NewMemoryStore(cfg.InitialValue)
+	}
+	return &fileStore{config: cfg, clock: clk, logger: logger, metrics: metrics}
+}
+
+// fileStore keeps the counter in cfg.Filename
+type fileStore struct {
+	config  *config.Config
+	clock   clock.Clock
+	logger  *zerolog.Logger
+	metrics *metrics.Metrics
+}
+
+// Load reads the counter file
+func (f *fileStore) Load() (*Counter, error) {
+	return LoadCounter(f.config, f.logger, f.metrics)
+}
+
+// Save writes the counter file
+func (f *fileStore) Save(ctx context.Context, counter *Counter, logger *zerolog.Logger) error {
+	return SaveCounter(ctx, counter, f.config, f.clock, logger, f.metrics)
+}
+
+// Verify checks the counter file against its CRC
+func (f *fileStore) Verify() (bool, error) {
+	return verifyCounterFile(f.config.Filename)
+}
+
+// Persistent is always true for the file store
+func (f *fileStore) Persistent() bool {
+	return true
+}
+
+// MemoryStore keeps the counter only in memory, for tests and ephemeral
+// deployments where a restart should start over. It never touches disk.
+type MemoryStore struct {
+	initialValue int64
+}
+
+// NewMemoryStore creates a MemoryStore whose counters start at initialValue
+func NewMemoryStore(initialValue int64) *MemoryStore {
+	return &MemoryStore{initialValue: initialValue}
+}
+
+// Load returns a new counter at the initial value
+func (m *MemoryStore) Load() (*Counter, error) {
+	return NewCounter(m.initialValue), nil
+}
+
+// Save marks the counter clean without storing anything
+func (m *MemoryStore) Save(ctx context.Context, counter *Counter, logger *zerolog.Logger) error {
+	counter.MarkClean(counter.GetValue())
+	return nil
+}
+
+// Verify always succeeds, as there is nothing stored to check
+func (m *MemoryStore) Verify() (bool, error) {
+	return true, nil
+}
+
+// Persistent is always false for the memory store
+func (m *MemoryStore) Persistent() bool {
+	return false
+}
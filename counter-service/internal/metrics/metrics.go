@@ -1,12 +1,24 @@
 package metrics
 
 import (
+	"runtime"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/yourusername/counter-service/internal/config"
 )
 
 // Metrics holds Prometheus metrics for the application
 type Metrics struct {
+	// Registry is the dedicated registry all metrics are registered on,
+	// kept separate from the global default registry
+	Registry *prometheus.Registry
+
+	// namespace and subsystem prefix the names of this service's metrics
+	namespace string
+	subsystem string
+
 	// RequestsTotal counts the total number of HTTP requests
 	RequestsTotal *prometheus.CounterVec
 
@@ -19,49 +31,271 @@ type Metrics struct {
 	// CounterValue is the current value of the counter
 	CounterValue prometheus.Gauge
 
+	// PeakValue is the highest value the counter has held
+	PeakValue prometheus.Gauge
+
 	// OperationDuration measures the duration of counter operations
 	OperationDuration *prometheus.HistogramVec
 
+	// IncrementsPerSecond is the average increment rate over the rate window
+	IncrementsPerSecond prometheus.Gauge
+
 	// PersistErrors counts errors during persistence operations
 	PersistErrors prometheus.Counter
+
+	// InsufficientSpaceErrors counts saves skipped because the disk was
+	// nearly full
+	InsufficientSpaceErrors prometheus.Counter
+
+	// CorruptionDetected counts counter files found corrupt at load, by
+	// kind: checksum or decode
+	CorruptionDetected *prometheus.CounterVec
+
+	// LastPersistTimestamp is the Unix time of the last successful save
+	LastPersistTimestamp prometheus.Gauge
+
+	// UnpersistedChanges is how far the counter has moved since it was last
+	// saved
+	UnpersistedChanges prometheus.Gauge
+
+	// PersistLag is the time in seconds since the last successful save, or
+	// since startup if there has been none
+	PersistLag prometheus.Gauge
+
+	// BackgroundRestarts counts relaunches of the background persistence
+	// loop after a panic
+	BackgroundRestarts prometheus.Counter
+
+	// ReadOnly is 1 while persistence is disabled because the counter file
+	// cannot be written
+	ReadOnly prometheus.Gauge
+
+	// Degraded is 1 while background saves are backed off after repeated
+	// failures
+	Degraded prometheus.Gauge
+
+	// ResponseSize measures the size of HTTP response bodies in bytes
+	ResponseSize *prometheus.HistogramVec
+
+	// InFlightRequests is the number of HTTP requests currently being served
+	InFlightRequests prometheus.Gauge
+
+	// RateLimitRejections counts requests rejected by the rate limiter
+	RateLimitRejections *prometheus.CounterVec
+
+	// ConnectionsRejected counts connections closed because the server
+	// already had the maximum number open
+	ConnectionsRejected prometheus.Counter
+
+	// StartTime is the Unix time the counter service started
+	StartTime prometheus.Gauge
+
+	// BuildInfo is always 1 and labels the running build
+	BuildInfo *prometheus.GaugeVec
 }
 
-// NewMetrics creates and registers Prometheus metrics
-func NewMetrics() *Metrics {
+// defaultSubsystem keeps the counter_ prefix when no subsystem is configured
+const defaultSubsystem = "counter"
+
+// NewMetrics creates Prometheus metrics and registers them on a new registry,
+// so it is safe to call more than once per process. Histogram buckets
+// come from cfg when set, otherwise the Prometheus defaults are used.
+// Metric names are prefixed with cfg.MetricNamespace and cfg.MetricSubsystem.
+func NewMetrics(cfg *config.Config) *Metrics {
+	namespace := cfg.MetricNamespace
+	subsystem := cfg.MetricSubsystem
+	if subsystem == "" {
+		subsystem = defaultSubsystem
+	}
+
+	requestBuckets := cfg.RequestDurationBuckets
+	if len(requestBuckets) == 0 {
+		requestBuckets = prometheus.DefBuckets
+	}
+	operationBuckets := cfg.OperationDurationBuckets
+	if len(operationBuckets) == 0 {
+		operationBuckets = prometheus.DefBuckets
+	}
+
 	// Create metrics
+	// Use a dedicated registry with Go runtime and process collectors
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(registry)
+
 	metrics := &Metrics{
-		RequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "counter_requests_total",
-			Help: "The total number of HTTP requests",
+		Registry:  registry,
+		namespace: namespace,
+		subsystem: subsystem,
+
+		RequestsTotal: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "The total number of HTTP requests",
 		}, []string{"method", "endpoint", "status"}),
 
-		RequestDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
-			Name:    "counter_request_duration_seconds",
-			Help:    "The duration of HTTP requests in seconds",
-			Buckets: prometheus.DefBuckets,
+		RequestDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "The duration of HTTP requests in seconds",
+			Buckets:   requestBuckets,
 		}, []string{"endpoint"}),
 
-		CounterOperations: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name: "counter_operations_total",
-			Help: "The total number of counter operations",
+		CounterOperations: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "operations_total",
+			Help:      "The total number of counter operations",
 		}, []string{"operation"}),
 
-		CounterValue: promauto.NewGauge(prometheus.GaugeOpts{
-			Name: "counter_current_value",
-			Help: "The current value of the counter",
+		CounterValue: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "current_value",
+			Help:      "The current value of the counter",
 		}),
 
-		OperationDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
-			Name:    "counter_operation_duration_seconds",
-			Help:    "Duration of counter operations in seconds",
-			Buckets: prometheus.DefBuckets,
+		PeakValue: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "peak_value",
+			Help:      "The highest value the counter has held",
+		}),
+
+		OperationDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of counter operations in seconds",
+			Buckets:   operationBuckets,
 		}, []string{"operation"}),
 
-		PersistErrors: promauto.NewCounter(prometheus.CounterOpts{
-			Name: "counter_persist_errors_total",
-			Help: "Total number of errors during counter persistence",
+		IncrementsPerSecond: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "increments_per_second",
+			Help:      "Average counter increments per second over the rate window",
+		}),
+
+		PersistErrors: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "persist_errors_total",
+			Help:      "Total number of errors during counter persistence",
 		}),
+
+		InsufficientSpaceErrors: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "persist_insufficient_space_total",
+			Help:      "Total number of saves skipped because free disk space was below the minimum",
+		}),
+
+		CorruptionDetected: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "corruption_detected_total",
+			Help:      "Total number of corrupt counter files found at load, which reset the counter",
+		}, []string{"kind"}),
+
+		LastPersistTimestamp: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "last_persist_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful counter persistence",
+		}),
+
+		UnpersistedChanges: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "unpersisted_changes",
+			Help:      "How far the counter has moved since it was last saved",
+		}),
+
+		PersistLag: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "persist_lag_seconds",
+			Help:      "Seconds since the last successful counter persistence, or since startup if there has been none",
+		}),
+
+		BackgroundRestarts: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "background_persistence_restarts_total",
+			Help:      "Total number of times background persistence was restarted after a panic",
+		}),
+
+		ReadOnly: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "persistence_read_only",
+			Help:      "1 while counter persistence is disabled because the data directory is not writable",
+		}),
+
+		Degraded: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "persistence_degraded",
+			Help:      "1 while background saves are backed off after repeated failures",
+		}),
+
+		ResponseSize: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "response_size_bytes",
+			Help:      "The size of HTTP response bodies in bytes",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"endpoint"}),
+
+		InFlightRequests: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "in_flight_requests",
+			Help:      "The number of HTTP requests currently being served",
+		}),
+
+		RateLimitRejections: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rate_limit_rejections_total",
+			Help:      "The total number of requests rejected by the rate limiter",
+		}, []string{"endpoint"}),
+
+		ConnectionsRejected: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "connections_rejected_total",
+			Help:      "The total number of connections closed because maxConnections were already open",
+		}),
+
+		StartTime: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "start_time_seconds",
+			Help:      "Unix timestamp of when the counter service started",
+		}),
+
+		BuildInfo: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "build_info",
+			Help:      "A metric with a constant '1' value labeled by version, Go version and git commit",
+		}, []string{"version", "goversion", "commit"}),
 	}
 
+	// Record the running build once
+	metrics.BuildInfo.WithLabelValues(config.Version, runtime.Version(), config.GitCommit).Set(1)
+
 	return metrics
-}
\ No newline at end of file
+}
+
+// name returns the full name of one of this service's metrics
+func (m *Metrics) name(name string) string {
+	return prometheus.BuildFQName(m.namespace, m.subsystem, name)
+}
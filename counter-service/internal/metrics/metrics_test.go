@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// gatherFamily scrapes m and returns the metric family called name, or nil
+func gatherFamily(t *testing.T, m *Metrics, name string) *dto.MetricFamily {
+	t.Helper()
+
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	return nil
+}
+
+func TestNewMetricsHistogramBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		buckets []float64
+		want    int
+	}{
+		{"custom", []float64{0.001, 0.01, 0.1}, 3},
+		{"default", nil, len(prometheus.DefBuckets)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMetrics(&config.Config{RequestDurationBuckets: tc.buckets, OperationDurationBuckets: tc.buckets})
+			m.RequestDuration.WithLabelValues("/api/counter").Observe(0.005)
+			m.OperationDuration.WithLabelValues("save").Observe(0.005)
+
+			for _, name := range []string{"counter_request_duration_seconds", "counter_operation_duration_seconds"} {
+				family := gatherFamily(t, m, name)
+				if family == nil {
+					t.Fatalf("%s not gathered", name)
+				}
+				if got := len(family.GetMetric()[0].GetHistogram().GetBucket()); got != tc.want {
+					t.Errorf("%s has %d buckets, want %d", name, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewMetricsRuntimeCollectors(t *testing.T) {
+	m := NewMetrics(&config.Config{})
+
+	for _, name := range []string{"go_goroutines", "go_memstats_alloc_bytes", "process_cpu_seconds_total"} {
+		if gatherFamily(t, m, name) == nil {
+			t.Errorf("%s not gathered", name)
+		}
+	}
+}
+
+func TestNewMetricsTwice(t *testing.T) {
+	first := NewMetrics(&config.Config{})
+	second := NewMetrics(&config.Config{})
+
+	// Each has its own registry, so their values are independent
+	first.CounterValue.Set(1)
+	second.CounterValue.Set(2)
+
+	for m, want := range map[*Metrics]float64{first: 1, second: 2} {
+		family := gatherFamily(t, m, "counter_current_value")
+		if family == nil {
+			t.Fatal("counter_current_value not gathered")
+		}
+		if got := family.GetMetric()[0].GetGauge().GetValue(); got != want {
+			t.Errorf("counter_current_value = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNewMetricsNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		namespace string
+		subsystem string
+		want      string
+	}{
+		{"default", "", "", "counter_requests_total"},
+		{"namespace", "myapp", "", "myapp_counter_requests_total"},
+		{"namespace and subsystem", "myapp", "visits", "myapp_visits_requests_total"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMetrics(&config.Config{MetricNamespace: tc.namespace, MetricSubsystem: tc.subsystem})
+			m.RequestsTotal.WithLabelValues("GET", "/api/counter", "200").Inc()
+
+			if gatherFamily(t, m, tc.want) == nil {
+				t.Errorf("Gather has no %s", tc.want)
+			}
+
+			// Summary looks metrics up by their prefixed names
+			summary, err := m.Summary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Requests != 1 {
+				t.Errorf("Summary requests = %v, want 1", summary.Requests)
+			}
+		})
+	}
+}
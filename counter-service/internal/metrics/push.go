@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/yourusername/counter-service/internal/config"
+)
+
+// Pusher sends the metrics to a Prometheus Pushgateway, for runs that end
+// before they can be scraped
+type Pusher struct {
+	pusher *push.Pusher
+}
+
+// NewPusher creates a Pusher for cfg.PushgatewayURL. Pushed metrics are
+// grouped by the host and the counter file name, so instances and counters
+// do not overwrite each other.
+func (m *Metrics) NewPusher(cfg *config.Config) *Pusher {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+
+	return &Pusher{
+		pusher: push.New(cfg.PushgatewayURL, cfg.PushgatewayJob).
+			Gatherer(m.Registry).
+			Grouping("instance", instance).
+			Grouping("counter", filepath.Base(cfg.Filename)),
+	}
+}
+
+// Push replaces the metrics of this group on the Pushgateway with the
+// current values
+func (p *Pusher) Push(ctx context.Context) error {
+	return p.pusher.PushContext(ctx)
+}
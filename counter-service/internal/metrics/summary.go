@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Summary is a point-in-time snapshot of the main metrics for consumers that
+// cannot scrape Prometheus
+type Summary struct {
+	CounterValue    float64
+	Increments      float64
+	Gets            float64
+	Imports         float64
+	Requests        float64
+	RequestErrors   float64
+	PersistErrors   float64
+	StartTime       time.Time
+	LastPersistTime time.Time
+}
+
+// incrementOperations are the counter operations that add to the counter
+var incrementOperations = map[string]bool{
+	"increment":             true,
+	"compare_and_increment": true,
+}
+
+// Summary gathers the registry and condenses it into a Summary, so the
+// values always match what /metrics reports. Times are zero when the
+// underlying metric is missing or unset.
+func (m *Metrics) Summary() (Summary, error) {
+	families, err := m.Registry.Gather()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var s Summary
+	for _, family := range families {
+		switch family.GetName() {
+		case m.name("current_value"):
+			s.CounterValue = sumGauges(family)
+		case m.name("operations_total"):
+			for _, metric := range family.GetMetric() {
+				op := labelValue(metric, "operation")
+				switch {
+				case incrementOperations[op]:
+					s.Increments += metric.GetCounter().GetValue()
+				case op == "get":
+					s.Gets += metric.GetCounter().GetValue()
+				case op == "import":
+					s.Imports += metric.GetCounter().GetValue()
+				}
+			}
+		case m.name("requests_total"):
+			for _, metric := range family.GetMetric() {
+				s.Requests += metric.GetCounter().GetValue()
+				if status, err := strconv.Atoi(labelValue(metric, "status")); err == nil && status >= 400 {
+					s.RequestErrors += metric.GetCounter().GetValue()
+				}
+			}
+		case m.name("persist_errors_total"):
+			for _, metric := range family.GetMetric() {
+				s.PersistErrors += metric.GetCounter().GetValue()
+			}
+		case m.name("start_time_seconds"):
+			s.StartTime = unixSeconds(sumGauges(family))
+		case m.name("last_persist_timestamp_seconds"):
+			s.LastPersistTime = unixSeconds(sumGauges(family))
+		}
+	}
+	return s, nil
+}
+
+// sumGauges adds up the gauge values in family
+func sumGauges(family *dto.MetricFamily) float64 {
+	var total float64
+	for _, metric := range family.GetMetric() {
+		total += metric.GetGauge().GetValue()
+	}
+	return total
+}
+
+// labelValue returns the value of the named label on metric
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// unixSeconds converts a Unix timestamp in seconds to a time, or the zero
+// time when unset
+func unixSeconds(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -12,9 +13,11 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/yourusername/counter-service/internal/api"
 	"github.com/yourusername/counter-service/internal/config"
 	"github.com/yourusername/counter-service/internal/counter"
 	"github.com/yourusername/counter-service/internal/metrics"
+	"github.com/yourusername/counter-service/pkg/clock"
 )
 
 // CreateTempFile creates a temporary file for testing
@@ -48,23 +51,52 @@ func NewTestConfig(t *testing.T) *config.Config {
 
 	// Create a test config
 	cfg := &config.Config{
-		Port:              "8099",
-		ReadTimeout:       1 * time.Second,
-		WriteTimeout:      1 * time.Second,
-		IdleTimeout:       5 * time.Second,
-		ShutdownTimeout:   1 * time.Second,
-		Filename:          path,
-		FilePermissions:   0644,
-		SaveRetryAttempts: 1,
-		SaveRetryDelay:    10 * time.Millisecond,
-		PersistInterval:   100 * time.Millisecond,
-		RateLimit:         100,
-		RateBurst:         200,
-		EnableMetrics:     true,
-		EnableCORS:        true,
-		AllowedOrigins:    []string{"*"},
-		LogLevel:          "fatal", // Silence logs during tests
-		Environment:       "test",
+		Port:                    "8099",
+		ReadTimeout:             1 * time.Second,
+		WriteTimeout:            1 * time.Second,
+		IdleTimeout:             5 * time.Second,
+		ShutdownTimeout:         1 * time.Second,
+		MaxBodyBytes:            64 << 10,
+		HandlerTimeout:          500 * time.Millisecond,
+		Filename:                path,
+		FilePermissions:         0644,
+		SaveRetryAttempts:       1,
+		SaveRetryDelay:          10 * time.Millisecond,
+		SaveRetryMaxDelay:       100 * time.Millisecond,
+		LockTimeout:             time.Second,
+		FsyncOnWrite:            true,
+		FsyncDirectory:          true,
+		SerializationFormat:     "json",
+		IntegrityAlgorithm:      "crc32",
+		PrettyPrintFile:         true,
+		PersistInterval:         100 * time.Millisecond,
+		PersistDebounce:         10 * time.Millisecond,
+		DegradeAfterFailures:    3,
+		DegradedRetryMaxDelay:   time.Second,
+		RateLimit:               100,
+		RateBurst:               200,
+		IdempotencyCacheSize:    100,
+		IdempotencyTTL:          time.Minute,
+		MaxValue:                math.MaxInt64,
+		IncrementRateWindow:     time.Minute,
+		EnableMetrics:           true,
+		EnableCORS:              true,
+		EnableGzip:              true,
+		GzipMinBytes:            1024,
+		EnableSecurityHeaders:   true,
+		EnvelopeResponses:       true,
+		StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+		AllowedOrigins:          []string{"*"},
+		CORSAllowCredentials:    false,
+		CORSAllowedMethods:      []string{"GET", "POST", "OPTIONS"},
+		CORSAllowedHeaders:      []string{"Content-Type", "Authorization", "X-Request-ID", "Idempotency-Key", "X-Dry-Run"},
+		CORSMaxAge:              5 * time.Minute,
+		LogLevel:                "fatal", // Silence logs during tests
+		LogSampleRate:           1,
+		SlowRequestThreshold:    500 * time.Millisecond,
+		RedactHeaders:           []string{"Authorization", "X-API-Key"},
+		RedactQueryParams:       []string{"token", "api_key"},
+		Environment:             "test",
 	}
 
 	return cfg
@@ -77,21 +109,37 @@ func NewTestLogger() *zerolog.Logger {
 	return &logger
 }
 
-// NewTestMetrics creates metrics for testing
+// NewTestMetrics creates metrics for testing with default histogram buckets.
+// Each call gets its own registry, so it can be called once per test.
 func NewTestMetrics() *metrics.Metrics {
-	return metrics.NewMetrics()
+	return metrics.NewMetrics(&config.Config{})
 }
 
-// NewTestCounterService creates a counter service for testing
+// NewTestClock creates a fake clock at a fixed time, so time-dependent
+// behavior only happens when the test advances it
+func NewTestClock() *clock.Fake {
+	return clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// NewTestCounterService creates a counter service for testing, driven by a
+// fake clock that never advances
 func NewTestCounterService(t *testing.T) *counter.Service {
 	t.Helper()
 
+	return NewTestCounterServiceWithClock(t, NewTestClock())
+}
+
+// NewTestCounterServiceWithClock creates a counter service for testing
+// driven by clk, which the test advances to trigger background work
+func NewTestCounterServiceWithClock(t *testing.T, clk clock.Clock) *counter.Service {
+	t.Helper()
+
 	cfg := NewTestConfig(t)
 	logger := NewTestLogger()
 	metrics := NewTestMetrics()
 
 	// Create a test counter service
-	service, err := counter.NewService(cfg, logger, metrics)
+	service, err := counter.NewServiceWithClock(cfg, clk, logger, metrics)
 	if err != nil {
 		t.Fatalf("Failed to create counter service: %v", err)
 	}
@@ -104,6 +152,33 @@ func NewTestCounterService(t *testing.T) *counter.Service {
 	return service
 }
 
+// NewTestServer starts the full API, with its middleware, on a local
+// httptest server backed by a real counter service and a temporary counter
+// file, for end-to-end tests against the returned server's URL. The server
+// and the service are shut down when the test is done.
+func NewTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	cfg := NewTestConfig(t)
+	logger := NewTestLogger()
+	metrics := NewTestMetrics()
+
+	service, err := counter.NewService(cfg, logger, metrics)
+	if err != nil {
+		t.Fatalf("Failed to create counter service: %v", err)
+	}
+
+	server := httptest.NewServer(api.NewServer(cfg, logger, service, metrics).Handler())
+
+	// Stop taking requests before the final save
+	t.Cleanup(func() {
+		server.Close()
+		service.Shutdown()
+	})
+
+	return server
+}
+
 // PerformRequest performs an HTTP request against a handler for testing
 func PerformRequest(t *testing.T, method, path string, body interface{}, handler http.Handler) *httptest.ResponseRecorder {
 	t.Helper()
@@ -131,4 +206,4 @@ func PerformRequest(t *testing.T, method, path string, body interface{}, handler
 	handler.ServeHTTP(w, req)
 
 	return w
-}
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// getVisits sends a request to url and returns the visits in the response
+func getVisits(t *testing.T, method, url string) int64 {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s status = %d, want 200", method, url, resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			Visits int64 `json:"visits"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return body.Data.Visits
+}
+
+func TestNewTestServer(t *testing.T) {
+	server := NewTestServer(t)
+
+	for want := int64(1); want <= 3; want++ {
+		if got := getVisits(t, http.MethodPost, server.URL+"/api/counter/increment"); got != want {
+			t.Fatalf("Increment returned %d, want %d", got, want)
+		}
+	}
+	if got := getVisits(t, http.MethodGet, server.URL+"/api/counter"); got != 3 {
+		t.Errorf("Counter = %d after 3 increments, want 3", got)
+	}
+}
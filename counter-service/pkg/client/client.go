@@ -0,0 +1,349 @@
+// Package client is a Go client for the counter service HTTP API. It
+// handles responses with or without the service's response envelope and
+// reports failed calls as *APIError.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds each request when no HTTP client is supplied
+const defaultTimeout = 10 * time.Second
+
+// maxResponseBytes caps how much of a response body is read
+const maxResponseBytes = 1 << 20
+
+// Retry defaults, used when the corresponding RetryOptions field is zero
+const (
+	defaultMaxAttempts = 3
+	defaultBaseDelay   = 100 * time.Millisecond
+	defaultMaxDelay    = 5 * time.Second
+)
+
+// idempotencyKeyHeader makes increments safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Options configures a Client
+type Options struct {
+	// APIKey is sent as a bearer token when set
+	APIKey string
+
+	// HTTPClient sends the requests, defaulting to a client with a 10s timeout
+	HTTPClient *http.Client
+
+	// Retry controls retries of idempotent calls
+	Retry RetryOptions
+}
+
+// RetryOptions controls retries of reads, health checks and increments made
+// with an idempotency key. Plain increments are never retried, because a
+// lost response would otherwise count twice.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts including the first,
+	// 3 when zero. Set it to 1 to disable retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubling for each
+	// further retry, 100ms when zero
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff delay, 5s when zero. A longer Retry-After
+	// from the server is still honored.
+	MaxDelay time.Duration
+}
+
+// Client calls the counter service API
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+	retry      RetryOptions
+}
+
+// Health is the payload of the health endpoint
+type Health struct {
+	Status        string    `json:"status"`
+	Version       string    `json:"version"`
+	Timestamp     time.Time `json:"timestamp"`
+	StartedAt     time.Time `json:"startedAt"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+}
+
+// APIError is returned for responses with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+
+	// RetryAfter is how long the server asked clients to wait, zero if it
+	// did not say
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("counter service returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("counter service returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// envelope is the standard response wrapper used by every endpoint
+type envelope struct {
+	Success   bool            `json:"success"`
+	Data      json.RawMessage `json:"data"`
+	Error     string          `json:"error"`
+	ErrorCode string          `json:"error_code"`
+	RequestID string          `json:"request_id"`
+}
+
+// New creates a client for the service at baseURL, e.g.
+// "http://localhost:8090"
+func New(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	retry := opts.Retry
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = defaultMaxAttempts
+	}
+	if retry.BaseDelay <= 0 {
+		retry.BaseDelay = defaultBaseDelay
+	}
+	if retry.MaxDelay <= 0 {
+		retry.MaxDelay = defaultMaxDelay
+	}
+
+	return &Client{
+		baseURL:    u,
+		apiKey:     opts.APIKey,
+		httpClient: httpClient,
+		retry:      retry,
+	}, nil
+}
+
+// Increment increments the counter and returns the new value. It is not
+// retried; use IncrementWithKey for retries.
+func (c *Client) Increment(ctx context.Context) (int64, error) {
+	var data struct {
+		Visits int64 `json:"visits"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/counter/increment", nil, false, &data); err != nil {
+		return 0, err
+	}
+	return data.Visits, nil
+}
+
+// IncrementWithKey increments the counter once for key, retrying failed
+// attempts. The server replays the first result for a repeated key, so a
+// retry after a lost response does not count twice.
+func (c *Client) IncrementWithKey(ctx context.Context, key string) (int64, error) {
+	var data struct {
+		Visits int64 `json:"visits"`
+	}
+	header := http.Header{idempotencyKeyHeader: []string{key}}
+	if err := c.do(ctx, http.MethodPost, "/api/counter/increment", header, true, &data); err != nil {
+		return 0, err
+	}
+	return data.Visits, nil
+}
+
+// Get returns the current counter value
+func (c *Client) Get(ctx context.Context) (int64, error) {
+	var data struct {
+		Visits int64 `json:"visits"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/counter", nil, true, &data); err != nil {
+		return 0, err
+	}
+	return data.Visits, nil
+}
+
+// Health returns the service health
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var health Health
+	if err := c.do(ctx, http.MethodGet, "/health", nil, true, &health); err != nil {
+		return Health{}, err
+	}
+	return health, nil
+}
+
+// do sends a request, retrying transient failures when retry is set, and
+// decodes the data of a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, retry bool, out interface{}) error {
+	attempts := 1
+	if retry {
+		attempts = c.retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := c.doOnce(ctx, method, path, header, out)
+		if err == nil || attempt >= attempts || !retryable(ctx, err) {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+
+		// Give up now rather than sleep past the caller's deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// backoff returns the delay before retry number attempt: exponential from
+// BaseDelay, capped at MaxDelay, with jitter over its upper half so
+// clients that failed together do not retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.retry.BaseDelay
+	for i := 1; i < attempt && delay < c.retry.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.retry.MaxDelay {
+		delay = c.retry.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryable reports whether a failed attempt may succeed if repeated
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// Connection failures and timeouts from the transport
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// doOnce sends a single request
+func (c *Client) doOnce(ctx context.Context, method, path string, header http.Header, out interface{}) error {
+	u := *c.baseURL
+	u.Path += path
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// A server with envelopeResponses off drops the wrapper: successes send
+	// just the data and errors send the error fields at the top level, which
+	// decode into envelope the same way
+	var fields map[string]json.RawMessage
+	decodeErr := json.Unmarshal(body, &fields)
+	_, enveloped := fields["success"]
+	var env envelope
+	if decodeErr == nil {
+		decodeErr = json.Unmarshal(body, &env)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+		if decodeErr == nil {
+			apiErr.Code = env.ErrorCode
+			apiErr.Message = env.Error
+			apiErr.RequestID = env.RequestID
+		}
+		return apiErr
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode response: %w", decodeErr)
+	}
+	if out == nil {
+		return nil
+	}
+
+	data := json.RawMessage(body)
+	if enveloped {
+		data = env.Data
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return errors.New("response has no data")
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response data: %w", err)
+	}
+	return nil
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date, returning zero when it is absent or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
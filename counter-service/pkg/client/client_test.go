@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeService serves the envelope responses of the counter service, checking
+// the bearer token when apiKey is set
+func fakeService(t *testing.T, apiKey string) *httptest.Server {
+	t.Helper()
+
+	visits := int64(41)
+	reply := func(w http.ResponseWriter, status int, env map[string]interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(env)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/counter/increment", func(w http.ResponseWriter, r *http.Request) {
+		visits++
+		reply(w, http.StatusOK, map[string]interface{}{"success": true, "data": map[string]int64{"visits": visits}})
+	})
+	mux.HandleFunc("GET /api/counter", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"success": true, "data": map[string]int64{"visits": visits}})
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"success": true, "data": map[string]interface{}{
+			"status": "healthy", "version": "1.2.3", "uptimeSeconds": 12.5,
+		}})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey != "" && r.Header.Get("Authorization") != "Bearer "+apiKey {
+			reply(w, http.StatusUnauthorized, map[string]interface{}{
+				"success": false, "error": "Authentication required", "error_code": "UNAUTHORIZED", "request_id": "req-1",
+			})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := fakeService(t, "secret")
+	c, err := New(server.URL+"/", Options{APIKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if visits, err := c.Increment(ctx); err != nil || visits != 42 {
+		t.Errorf("Increment = %d, %v; want 42, nil", visits, err)
+	}
+	if visits, err := c.Get(ctx); err != nil || visits != 42 {
+		t.Errorf("Get = %d, %v; want 42, nil", visits, err)
+	}
+	health, err := c.Health(ctx)
+	if err != nil || health.Status != "healthy" || health.Version != "1.2.3" || health.UptimeSeconds != 12.5 {
+		t.Errorf("Health = %+v, %v; want the served payload", health, err)
+	}
+}
+
+func TestClientAPIError(t *testing.T) {
+	server := fakeService(t, "secret")
+	c, err := New(server.URL, Options{APIKey: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Get(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Get error = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "UNAUTHORIZED" || apiErr.Message == "" || apiErr.RequestID != "req-1" {
+		t.Errorf("APIError = %+v, want the decoded 401 envelope", apiErr)
+	}
+}
+
+// staticService answers every request with status and body
+func staticService(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientFlatResponses(t *testing.T) {
+	// With envelopeResponses off the service sends the data on its own
+	c, err := New(staticService(t, http.StatusOK, `{"visits":42,"durable":true}`).URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visits, err := c.Get(context.Background()); err != nil || visits != 42 {
+		t.Errorf("Get = %d, %v; want 42, nil", visits, err)
+	}
+
+	// and errors as top level fields
+	c, err = New(staticService(t, http.StatusConflict, `{"error":"Counter is at its maximum value","error_code":"OVERFLOW"}`).URL, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Increment(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Increment error = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Code != "OVERFLOW" || apiErr.Message != "Counter is at its maximum value" {
+		t.Errorf("APIError = %+v, want the decoded flat 409 error", apiErr)
+	}
+}
+
+func TestClientRejectsMissingData(t *testing.T) {
+	for _, body := range []string{`{"success":true}`, `{"success":true,"data":null}`} {
+		c, err := New(staticService(t, http.StatusOK, body).URL, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visits, err := c.Get(context.Background()); err == nil {
+			t.Errorf("Get with body %s = %d, nil; want an error", body, visits)
+		}
+	}
+}
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	for _, baseURL := range []string{"localhost:8090", "ftp://example.com", "http://[::1"} {
+		if _, err := New(baseURL, Options{}); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", baseURL)
+		}
+	}
+}
+
+// flakyService fails the first failures requests with status, then succeeds,
+// counting every request it receives
+func flakyService(t *testing.T, failures int, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if int(requests.Add(1)) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"success":false,"error":"Try again","error_code":"UNAVAILABLE"}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"data":{"visits":7}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// fastRetry retries quickly so tests do not wait on real backoff
+var fastRetry = RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestClientRetriesIdempotentCalls(t *testing.T) {
+	server, requests := flakyService(t, 2, http.StatusServiceUnavailable, "")
+	c, err := New(server.URL, Options{Retry: fastRetry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if visits, err := c.Get(context.Background()); err != nil || visits != 7 {
+		t.Fatalf("Get = %d, %v; want 7 after retries", visits, err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("%d requests, want 3", got)
+	}
+
+	// Keyed increments are safe to retry too
+	server, requests = flakyService(t, 1, http.StatusTooManyRequests, "")
+	c, _ = New(server.URL, Options{Retry: fastRetry})
+	if visits, err := c.IncrementWithKey(context.Background(), "key-1"); err != nil || visits != 7 {
+		t.Fatalf("IncrementWithKey = %d, %v; want 7 after a retry", visits, err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("%d keyed increment requests, want 2", got)
+	}
+}
+
+func TestClientDoesNotRetryPlainIncrement(t *testing.T) {
+	server, requests := flakyService(t, 1, http.StatusServiceUnavailable, "")
+	c, err := New(server.URL, Options{Retry: fastRetry})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var apiErr *APIError
+	if _, err := c.Increment(context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Increment error = %v, want the 503", err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d requests for a plain increment, want 1", got)
+	}
+}
+
+func TestClientGivesUp(t *testing.T) {
+	// Attempts are capped
+	server, requests := flakyService(t, 10, http.StatusBadGateway, "")
+	c, err := New(server.URL, Options{Retry: fastRetry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded, want the last 502")
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("%d requests, want MaxAttempts 3", got)
+	}
+
+	// Client errors are not retried
+	server, requests = flakyService(t, 10, http.StatusBadRequest, "")
+	c, _ = New(server.URL, Options{Retry: fastRetry})
+	c.Get(context.Background())
+	if got := requests.Load(); got != 1 {
+		t.Errorf("%d requests after a 400, want 1", got)
+	}
+
+	// A Retry-After past the deadline ends the call instead of sleeping
+	server, requests = flakyService(t, 10, http.StatusServiceUnavailable, "30")
+	c, _ = New(server.URL, Options{Retry: fastRetry})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Get(ctx)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 30*time.Second {
+		t.Errorf("Get error = %v, want the 503 with its Retry-After", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond || requests.Load() != 1 {
+		t.Errorf("Gave up after %v and %d requests, want at once after 1", elapsed, requests.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      0,
+		"3":     3 * time.Second,
+		"0":     0,
+		"-1":    0,
+		"later": 0,
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	at := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(at); got <= 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, want about a minute", at, got)
+	}
+}
@@ -0,0 +1,62 @@
+package clock
+
+import "time"
+
+// Clock provides the current time and timers, so time-dependent code can be
+// driven by a Fake in tests
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker returns a ticker that fires every d
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine once d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker delivers ticks on a channel at regular intervals
+type Ticker interface {
+	// C returns the channel ticks are delivered on
+	C() <-chan time.Time
+
+	// Stop turns off the ticker
+	Stop()
+}
+
+// Timer runs a function once after a delay
+type Timer interface {
+	// Stop prevents the timer from firing, reporting whether it was active
+	Stop() bool
+
+	// Reset changes the timer to fire after d, reporting whether it was active
+	Reset(d time.Duration) bool
+}
+
+// Real is a Clock backed by the time package
+type Real struct{}
+
+// Now returns time.Now()
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker wraps time.NewTicker
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// AfterFunc wraps time.AfterFunc
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// realTicker adapts *time.Ticker to the Ticker interface
+type realTicker struct {
+	*time.Ticker
+}
+
+// C returns the ticker's channel
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
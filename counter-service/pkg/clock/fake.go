@@ -0,0 +1,150 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called. Tickers and
+// timers due within the advanced period fire in order; timer functions run
+// synchronously inside Advance.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a pending ticker or timer on a Fake clock
+type fakeWaiter struct {
+	clock  *Fake
+	when   time.Time
+	period time.Duration // zero for timers
+	ch     chan time.Time
+	fn     func()
+}
+
+// NewFake creates a Fake clock set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTicker returns a ticker that fires as the clock is advanced
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{clock: f, when: f.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return fakeTicker{w}
+}
+
+// AfterFunc returns a timer that calls fn once the clock is advanced by d
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{clock: f, when: f.now.Add(d), fn: fn}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d, firing everything that falls due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+
+	for {
+		w := f.nextDue(end)
+		if w == nil {
+			break
+		}
+		f.now = w.when
+
+		if w.period > 0 {
+			// Drop the tick if the last one was not received, like time.Ticker
+			select {
+			case w.ch <- f.now:
+			default:
+			}
+			w.when = w.when.Add(w.period)
+			continue
+		}
+
+		f.remove(w)
+		f.mu.Unlock()
+		w.fn()
+		f.mu.Lock()
+	}
+
+	f.now = end
+	f.mu.Unlock()
+}
+
+// nextDue returns the earliest waiter due at or before end. The caller must
+// hold mu.
+func (f *Fake) nextDue(end time.Time) *fakeWaiter {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].when.Before(f.waiters[j].when)
+	})
+	if len(f.waiters) == 0 || f.waiters[0].when.After(end) {
+		return nil
+	}
+	return f.waiters[0]
+}
+
+// remove drops w from the pending waiters, reporting whether it was there.
+// The caller must hold mu.
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTicker adapts a periodic fakeWaiter to the Ticker interface
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+// C returns the channel ticks are delivered on
+func (t fakeTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop removes the ticker from the clock
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
+
+// Stop removes the timer from the clock
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+// Reset reschedules the timer to fire d after the clock's current time
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	active := w.clock.remove(w)
+	w.when = w.clock.now.Add(d)
+	w.clock.waiters = append(w.clock.waiters, w)
+	return active
+}
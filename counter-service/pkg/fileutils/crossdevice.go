@@ -0,0 +1,8 @@
+//go:build !plan9
+
+package fileutils
+
+import "syscall"
+
+// errCrossDevice is the error a rename across filesystems fails with
+var errCrossDevice error = syscall.EXDEV
@@ -0,0 +1,7 @@
+package fileutils
+
+import "errors"
+
+// errCrossDevice is never returned on Plan 9, which has no EXDEV, so MoveFile
+// does not fall back to copying there
+var errCrossDevice = errors.New("cross-device rename")
@@ -1,12 +1,18 @@
 package fileutils
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 )
 
+// maxVerifySize bounds how much of a file VerifyFile will read
+const maxVerifySize = 1 << 20
+
 // CalculateCRC computes a simple checksum for data validation
 func CalculateCRC(data []byte) uint32 {
 	var crc uint32 = 0
@@ -36,8 +42,10 @@ func EnsureDirectory(path string) error {
 	return nil
 }
 
-// AtomicWriteFile writes data to a file atomically using a temporary file
-func AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+// AtomicWriteFile writes data to a file atomically using a temporary file.
+// With syncDir set, the parent directory is flushed after the rename so the
+// rename itself survives power loss (see SyncDir for platform caveats).
+func AtomicWriteFile(filename string, data []byte, perm os.FileMode, syncDir bool) error {
 	// Ensure directory exists
 	if err := EnsureDirectory(filename); err != nil {
 		return err
@@ -81,14 +89,233 @@ func AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
 	}
 
 	// Rename the temp file (atomic on most filesystems)
-	if err = os.Rename(tempPath, filename); err != nil {
+	if _, err = MoveFile(tempPath, filename); err != nil {
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
+	// Make the rename durable
+	if syncDir {
+		return SyncDir(dir)
+	}
+
 	return nil
 }
 
-// ReadFileWithLimit reads a file with a size limit
+// rename is os.Rename, replaceable so the cross-device fallback of MoveFile
+// can be exercised without a second filesystem
+var rename = os.Rename
+
+// MoveFile renames src to dst. When they are on different filesystems and
+// the rename fails with EXDEV, it falls back to copying src over dst,
+// syncing the copy and removing src, and reports crossDevice so callers can
+// warn that the replacement was not atomic.
+func MoveFile(src, dst string) (crossDevice bool, err error) {
+	err = rename(src, dst)
+	if err == nil || !errors.Is(err, errCrossDevice) {
+		return false, err
+	}
+
+	if err := copyOver(src, dst); err != nil {
+		return true, fmt.Errorf("failed to copy across filesystems: %w", err)
+	}
+	if err := os.Remove(src); err != nil {
+		return true, fmt.Errorf("failed to remove source after copy: %w", err)
+	}
+	return true, nil
+}
+
+// copyOver overwrites dst with the contents and permissions of src and
+// syncs it to disk
+func copyOver(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// OpenFile only applies the mode to new files
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// CopyFile copies src to dst through a temporary file and an atomic rename,
+// syncing the copy before it becomes visible. A perm of zero keeps the
+// permissions of src. Without overwrite, an existing dst is left untouched
+// and an error wrapping os.ErrExist is returned. Copying a file onto itself
+// does nothing.
+func CopyFile(src, dst string, perm os.FileMode, overwrite bool) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer in.Close()
+
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+	if perm == 0 {
+		perm = srcInfo.Mode().Perm()
+	}
+
+	// Check the destination
+	if dstInfo, statErr := os.Stat(dst); statErr == nil {
+		if os.SameFile(srcInfo, dstInfo) {
+			return nil
+		}
+		if !overwrite {
+			return fmt.Errorf("destination %s: %w", dst, os.ErrExist)
+		}
+	}
+
+	// Ensure directory exists
+	if err := EnsureDirectory(dst); err != nil {
+		return err
+	}
+
+	// Create temp file in same directory
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := out.Name()
+
+	// Clean up on any error
+	defer func() {
+		if err != nil {
+			os.Remove(tempPath)
+		}
+	}()
+
+	// Copy and sync the data
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy data: %w", err)
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	// Set permissions
+	if err = os.Chmod(tempPath, perm); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if overwrite {
+		if err = os.Rename(tempPath, dst); err != nil {
+			return fmt.Errorf("failed to rename temp file: %w", err)
+		}
+		return nil
+	}
+
+	// Linking fails if dst appeared since the check above, so a file
+	// created concurrently is never clobbered
+	if err = os.Link(tempPath, dst); err != nil {
+		return fmt.Errorf("failed to link temp file: %w", err)
+	}
+	return os.Remove(tempPath)
+}
+
+// VerifyFile checks the CRC of a JSON file written with a trailing "crc"
+// field, as the counter persistence does: the CRC must match the file
+// without that field, either re-indented or compact. It reports false for unparseable files
+// and files without a CRC, and returns an error only if the file cannot be
+// read.
+func VerifyFile(path string) (bool, error) {
+	content, err := ReadFileWithLimit(path, maxVerifySize)
+	if err != nil {
+		return false, err
+	}
+
+	// Walk the top-level object, keeping fields in their original order
+	dec := json.NewDecoder(bytes.NewReader(content))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return false, nil
+	}
+
+	var body bytes.Buffer
+	var crc uint32
+	var hasCRC bool
+	body.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, nil
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return false, nil
+		}
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return false, nil
+		}
+
+		if key == "crc" {
+			if err := json.Unmarshal(value, &crc); err != nil {
+				return false, nil
+			}
+			hasCRC = true
+			continue
+		}
+
+		if body.Len() > 1 {
+			body.WriteByte(',')
+		}
+		keyBytes, _ := json.Marshal(key)
+		body.Write(keyBytes)
+		body.WriteByte(':')
+		if err := json.Compact(&body, value); err != nil {
+			return false, nil
+		}
+	}
+	if _, err := dec.Token(); err != nil || !hasCRC {
+		return false, nil
+	}
+	body.WriteByte('}')
+
+	// Match the json.Marshal layout the CRC may have been calculated over
+	if CalculateCRC(body.Bytes()) == crc {
+		return true, nil
+	}
+
+	// Otherwise match the json.MarshalIndent layout
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body.Bytes(), "", "  "); err != nil {
+		return false, nil
+	}
+
+	return CalculateCRC(indented.Bytes()) == crc, nil
+}
+
+// ReadFileWithLimit reads a file with a size limit. The file is streamed
+// rather than sized up front, so it copes with files that change size while
+// being read. A maxSize of zero or less means no limit.
 func ReadFileWithLimit(path string, maxSize int64) ([]byte, error) {
 	// Open file
 	file, err := os.Open(path)
@@ -97,22 +324,22 @@ func ReadFileWithLimit(path string, maxSize int64) ([]byte, error) {
 	}
 	defer file.Close()
 
-	// Get file size
-	stat, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to stat file: %w", err)
+	// Read one byte past the limit to tell an oversized file from one that
+	// is exactly at the limit
+	var r io.Reader = file
+	if maxSize > 0 {
+		r = io.LimitReader(file, maxSize+1)
 	}
 
-	// Check file size
-	if maxSize > 0 && stat.Size() > maxSize {
-		return nil, fmt.Errorf("file size %d exceeds maximum allowed size %d", stat.Size(), maxSize)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// Read file with limit
-	data := make([]byte, stat.Size())
-	if _, err := io.ReadFull(file, data); err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+	// Check file size
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file size exceeds maximum allowed size %d", maxSize)
 	}
 
 	return data, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,271 @@
+package fileutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failRename makes rename fail with err until the test ends
+func failRename(t *testing.T, err error) {
+	t.Helper()
+
+	saved := rename
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	t.Cleanup(func() { rename = saved })
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("moved"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	crossDevice, err := MoveFile(src, dst)
+	if err != nil || crossDevice {
+		t.Fatalf("MoveFile = %v, %v; want a plain rename", crossDevice, err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "moved" {
+		t.Errorf("Destination holds %q, want %q", got, "moved")
+	}
+}
+
+func TestMoveFileCrossDevice(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("new contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("old contents that are longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failRename(t, errCrossDevice)
+
+	crossDevice, err := MoveFile(src, dst)
+	if err != nil {
+		t.Fatalf("MoveFile failed: %v", err)
+	}
+	if !crossDevice {
+		t.Error("crossDevice = false, want the copy fallback reported")
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new contents" {
+		t.Errorf("Destination holds %q, want the source contents", got)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Destination mode = %v, want the source's 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("Source still exists after the move: %v", err)
+	}
+}
+
+func TestMoveFileOtherErrors(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("kept"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	failRename(t, os.ErrPermission)
+
+	crossDevice, err := MoveFile(src, filepath.Join(dir, "dst"))
+	if !errors.Is(err, os.ErrPermission) || crossDevice {
+		t.Fatalf("MoveFile = %v, %v; want the rename error without a fallback", crossDevice, err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("Source was touched: %v", err)
+	}
+}
+
+func TestAtomicWriteFile(t *testing.T) {
+	for _, syncDir := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "nested", "data.json")
+
+		if err := AtomicWriteFile(path, []byte("first"), 0640, syncDir); err != nil {
+			t.Fatalf("AtomicWriteFile with syncDir %v failed: %v", syncDir, err)
+		}
+		if err := AtomicWriteFile(path, []byte("second"), 0640, syncDir); err != nil {
+			t.Fatalf("AtomicWriteFile over an existing file with syncDir %v failed: %v", syncDir, err)
+		}
+
+		if got, _ := os.ReadFile(path); string(got) != "second" {
+			t.Errorf("syncDir %v: file holds %q, want %q", syncDir, got, "second")
+		}
+		entries, err := os.ReadDir(filepath.Dir(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("syncDir %v: directory holds %d entries, want only the file", syncDir, len(entries))
+		}
+	}
+}
+
+func TestReadFileWithLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		maxSize int64
+		wantErr bool
+	}{
+		{10, false}, // exactly at the limit
+		{9, true},   // one byte over
+		{0, false},  // no limit
+	} {
+		data, err := ReadFileWithLimit(path, tc.maxSize)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("maxSize %d: read %q, want a size error", tc.maxSize, data)
+			}
+			continue
+		}
+		if err != nil || string(data) != "0123456789" {
+			t.Errorf("maxSize %d = %q, %v; want the whole file", tc.maxSize, data, err)
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(src, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero perm keeps the source permissions
+	dst := filepath.Join(dir, "backup", "dst")
+	if err := CopyFile(src, dst, 0, false); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "original" {
+		t.Errorf("Copy holds %q, want %q", got, "original")
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Copy mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	// An explicit perm replaces them
+	explicit := filepath.Join(dir, "explicit")
+	if err := CopyFile(src, explicit, 0600, false); err != nil {
+		t.Fatalf("CopyFile with perm failed: %v", err)
+	}
+	info, err = os.Stat(explicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Copy mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Copying a file onto itself leaves it alone
+	if err := CopyFile(src, src, 0, true); err != nil {
+		t.Errorf("CopyFile onto itself = %v, want nil", err)
+	}
+	if got, _ := os.ReadFile(src); string(got) != "original" {
+		t.Errorf("Source holds %q after copying onto itself", got)
+	}
+}
+
+func TestCopyFileOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := CopyFile(src, dst, 0, false)
+	if !errors.Is(err, os.ErrExist) {
+		t.Fatalf("CopyFile without overwrite = %v, want os.ErrExist", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "existing" {
+		t.Errorf("Destination holds %q after a refused copy, want it untouched", got)
+	}
+
+	if err := CopyFile(src, dst, 0, true); err != nil {
+		t.Fatalf("CopyFile with overwrite failed: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "new" {
+		t.Errorf("Destination holds %q after overwriting, want %q", got, "new")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Directory holds %d entries, want no temp files left", len(entries))
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	body := `{"visits":42,"last_updated":"2024-01-02T03:04:05Z","version":"1"}`
+	crc := CalculateCRC([]byte(body))
+	sealed := fmt.Sprintf(`{"visits":42,"last_updated":"2024-01-02T03:04:05Z","version":"1","crc":%d}`, crc)
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(body), "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	indentedSealed := strings.TrimSuffix(indented.String(), "\n}") +
+		fmt.Sprintf(",\n  \"crc\": %d\n}", CalculateCRC(indented.Bytes()))
+
+	for _, tc := range []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"valid", sealed, true},
+		{"valid indented", indentedSealed, true},
+		{"corrupt body", sealed[:len(sealed)/2], false},
+		{"crc mismatch", strings.Replace(sealed, `"visits":42`, `"visits":43`, 1), false},
+		{"no crc", body, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "counter.json")
+			if err := os.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			valid, err := VerifyFile(path)
+			if err != nil || valid != tc.valid {
+				t.Errorf("VerifyFile = %v, %v; want %v, nil", valid, err, tc.valid)
+			}
+		})
+	}
+
+	if _, err := VerifyFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("VerifyFile on a missing file succeeded, want an error")
+	}
+}
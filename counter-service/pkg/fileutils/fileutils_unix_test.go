@@ -0,0 +1,42 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package fileutils
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// A FIFO reports a size of zero however much is written to it, so reading
+// one shows ReadFileWithLimit goes by what it reads rather than by stat, as
+// it must for files that shrink or grow while being read
+func TestReadFileWithLimitIgnoresStatSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("Cannot create a FIFO: %v", err)
+	}
+
+	write := func(content string) {
+		go func() {
+			f, err := os.OpenFile(path, os.O_WRONLY, 0)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			f.WriteString(content)
+		}()
+	}
+
+	write("streamed")
+	data, err := ReadFileWithLimit(path, 8)
+	if err != nil || string(data) != "streamed" {
+		t.Errorf("ReadFileWithLimit = %q, %v; want the streamed content", data, err)
+	}
+
+	write("streamed too far")
+	if data, err := ReadFileWithLimit(path, 8); err == nil {
+		t.Errorf("ReadFileWithLimit = %q over the limit, want a size error", data)
+	}
+}
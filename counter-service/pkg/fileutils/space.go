@@ -0,0 +1,18 @@
+//go:build aix || darwin || linux
+
+package fileutils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// AvailableSpace returns the bytes available to unprivileged users on the
+// filesystem containing path
+func AvailableSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem for %s: %w", path, err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
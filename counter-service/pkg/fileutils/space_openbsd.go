@@ -0,0 +1,20 @@
+package fileutils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// AvailableSpace returns the bytes available to unprivileged users on the
+// filesystem containing path
+func AvailableSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem for %s: %w", path, err)
+	}
+	// F_bavail goes negative once the reserved blocks are in use
+	if stat.F_bavail < 0 {
+		return 0, nil
+	}
+	return uint64(stat.F_bavail) * uint64(stat.F_bsize), nil
+}
@@ -0,0 +1,14 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || openbsd || windows)
+
+package fileutils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AvailableSpace cannot tell free space on this platform, so it always
+// returns an error matching errors.ErrUnsupported
+func AvailableSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("free space of %s is unknown: %w", path, errors.ErrUnsupported)
+}
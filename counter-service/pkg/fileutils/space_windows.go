@@ -0,0 +1,22 @@
+package fileutils
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// AvailableSpace returns the bytes available to the current user on the
+// volume containing path
+func AvailableSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, nil, nil); err != nil {
+		return 0, fmt.Errorf("failed to get free space for %s: %w", path, err)
+	}
+	return available, nil
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package fileutils
+
+import (
+	"fmt"
+	"os"
+)
+
+// SyncDir flushes a directory's entries to disk, making a preceding create
+// or rename in it durable. On Linux this is required on ext4 and XFS for
+// renames to survive power loss; macOS only guarantees durability with
+// F_FULLFSYNC, which fsync does not issue, so it narrows rather than closes
+// the window there.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package fileutils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := SyncDir(dir); err != nil {
+		t.Errorf("SyncDir on a directory failed: %v", err)
+	}
+
+	if err := SyncDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("SyncDir on a missing directory succeeded, want an error")
+	}
+}
@@ -0,0 +1,8 @@
+package fileutils
+
+// SyncDir is a no-op on Windows, where a directory cannot be opened for
+// flushing. NTFS journals the rename, but it may still be lost on power loss
+// until the journal reaches disk.
+func SyncDir(dir string) error {
+	return nil
+}
@@ -1,18 +1,56 @@
 package logging
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/diode"
 	"github.com/yourusername/counter-service/pkg/fileutils"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // NewLogger creates a new zerolog logger with appropriate configuration
 func NewLogger(logLevel string, environment string) *zerolog.Logger {
+	configureGlobals(logLevel)
+
+	logger := zerolog.New(newOutput(environment)).With().Timestamp().Caller().Logger()
+	return &logger
+}
+
+// NewAsyncLogger creates a logger like NewLogger whose writes go through a
+// ring buffer of bufferSize messages drained by a background goroutine, so
+// logging never blocks the caller. See NewAsyncWriter for the drop policy.
+// Close the returned io.Closer on shutdown to flush pending messages.
+func NewAsyncLogger(logLevel string, environment string, bufferSize int) (*zerolog.Logger, io.Closer) {
+	configureGlobals(logLevel)
+
+	out := NewAsyncWriter(newOutput(environment), bufferSize)
+	logger := zerolog.New(out).With().Timestamp().Caller().Logger()
+	return &logger, out
+}
+
+// NewAsyncWriter wraps w in a non-blocking ring buffer of bufferSize messages.
+//
+// Drop policy: when the buffer is full because w cannot keep up, the oldest
+// unwritten messages are overwritten and a count of dropped messages is
+// reported on stderr. Request handling is never slowed down by logging.
+func NewAsyncWriter(w io.Writer, bufferSize int) io.WriteCloser {
+	return diode.NewWriter(w, bufferSize, asyncPollInterval, func(missed int) {
+		fmt.Fprintf(os.Stderr, "logger dropped %d messages\n", missed)
+	})
+}
+
+// asyncPollInterval is how often the async writer drains its buffer
+const asyncPollInterval = 10 * time.Millisecond
+
+// configureGlobals applies the log level and caller formatting
+func configureGlobals(logLevel string) {
 	// Parse log level
 	level, err := zerolog.ParseLevel(logLevel)
 	if err != nil {
@@ -24,46 +62,116 @@ func NewLogger(logLevel string, environment string) *zerolog.Logger {
 
 	// Add caller info to log
 	zerolog.CallerMarshalFunc = shortenCallerPath
+}
 
-	// Create logger
-	var logger zerolog.Logger
+// utcTimestamps is set by UseUTC
+var utcTimestamps bool
 
-	// Set up pretty console logging for development
+// UseUTC makes loggers write their timestamps in UTC rather than local time.
+// Call it before creating loggers, since console output set up earlier keeps
+// showing local time.
+func UseUTC() {
+	utcTimestamps = true
+	zerolog.TimestampFunc = func() time.Time {
+		return time.Now().UTC()
+	}
+}
+
+// newOutput returns pretty console output for development and JSON otherwise
+func newOutput(environment string) io.Writer {
 	if environment == "development" {
-		logger = zerolog.New(zerolog.ConsoleWriter{
-			Out:        os.Stdout,
-			TimeFormat: time.RFC3339,
-		}).With().Timestamp().Caller().Logger()
-	} else {
-		// JSON logging for production
-		logger = zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+		return newConsoleWriter(os.Stdout)
 	}
+	return os.Stdout
+}
 
-	return &logger
+// newConsoleWriter returns human-readable output to out. The console writer
+// converts timestamps to local time, so after UseUTC they are formatted here.
+func newConsoleWriter(out io.Writer) zerolog.ConsoleWriter {
+	w := zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
+	if utcTimestamps {
+		w.FormatTimestamp = formatUTCTimestamp
+	}
+	return w
+}
+
+// formatUTCTimestamp formats a logged timestamp in UTC, passing through
+// values it cannot parse
+func formatUTCTimestamp(i interface{}) string {
+	s, ok := i.(string)
+	if !ok {
+		return fmt.Sprint(i)
+	}
+	t, err := time.Parse(zerolog.TimeFieldFormat, s)
+	if err != nil {
+		return s
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// FileLogOptions controls rotation of the log file
+type FileLogOptions struct {
+	// MaxSizeMB is the size in megabytes at which the file is rotated
+	MaxSizeMB int
+
+	// MaxBackups is the number of rotated files to keep (0 keeps all)
+	MaxBackups int
+
+	// MaxAgeDays is the number of days to keep rotated files (0 keeps all)
+	MaxAgeDays int
+
+	// AsyncBufferSize enables non-blocking writes through a ring buffer of
+	// this many messages when greater than zero
+	AsyncBufferSize int
 }
 
-// SetupFileLogging configures logging to a file in addition to stdout
-func SetupFileLogging(logger *zerolog.Logger, logPath string) error {
+// SetupFileLogging configures logging to a size-rotated file in addition to
+// stdout. Close the returned io.Closer on shutdown to flush and close the file.
+func SetupFileLogging(logger *zerolog.Logger, logPath string, opts FileLogOptions) (io.Closer, error) {
 	// Ensure log directory exists
 	if err := fileutils.EnsureDirectory(logPath); err != nil {
-		return fmt.Errorf("failed to create log directory: %w", err)
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	// Open log file
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+	// Rotating log file; rotated copies are renamed with a timestamp suffix
+	logFile := &lumberjack.Logger{
+		Filename:   logPath,
+		MaxSize:    opts.MaxSizeMB,
+		MaxBackups: opts.MaxBackups,
+		MaxAge:     opts.MaxAgeDays,
 	}
 
 	// Create multi-writer to log to both console and file
-	consoleWriter := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
-	multi := zerolog.MultiLevelWriter(consoleWriter, logFile)
+	consoleWriter := newConsoleWriter(os.Stdout)
+	var out io.Writer = zerolog.MultiLevelWriter(consoleWriter, logFile)
+	var closer io.Closer = logFile
+
+	// Optionally decouple writes from the caller
+	if opts.AsyncBufferSize > 0 {
+		async := NewAsyncWriter(out, opts.AsyncBufferSize)
+		out = async
+		closer = multiCloser{async, logFile}
+	}
 
 	// Update logger to use multi-writer
-	newLogger := zerolog.New(multi).With().Timestamp().Caller().Logger()
+	newLogger := zerolog.New(out).With().Timestamp().Caller().Logger()
 	*logger = newLogger
 
-	return nil
+	return closer, nil
+}
+
+// multiCloser closes each closer in order, returning the first error
+type multiCloser []io.Closer
+
+// Close closes all wrapped closers
+func (mc multiCloser) Close() error {
+	var firstErr error
+	for _, c := range mc {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // shortenCallerPath shortens the file path in logs
@@ -84,6 +192,15 @@ func LoggerWithRequestID(logger *zerolog.Logger, requestID string) *zerolog.Logg
 	return &newLogger
 }
 
+// FromContext returns the logger attached to ctx with zerolog's WithContext,
+// such as a request-scoped logger, or fallback when ctx has none
+func FromContext(ctx context.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l != zerolog.DefaultContextLogger && l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return fallback
+}
+
 // RecoveryFn creates a function to recover from panics with logging
 func RecoveryFn(logger *zerolog.Logger) func() {
 	return func() {
@@ -100,4 +217,4 @@ func RecoveryFn(logger *zerolog.Logger) func() {
 				Msg("Recovered from panic")
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSetupFileLoggingRotates(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "counter.log")
+
+	logger := zerolog.Nop()
+	closer, err := SetupFileLogging(&logger, logPath, FileLogOptions{MaxSizeMB: 1, MaxBackups: 3})
+	if err != nil {
+		t.Fatalf("SetupFileLogging failed: %v", err)
+	}
+
+	// Write a little over the 1MB limit
+	padding := strings.Repeat("x", 1024)
+	for i := 0; i < 1100; i++ {
+		logger.Info().Str("padding", padding).Int("i", i).Msg("filler")
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated []string
+	for _, entry := range entries {
+		if name := entry.Name(); name != "counter.log" && strings.HasPrefix(name, "counter-") && strings.HasSuffix(name, ".log") {
+			rotated = append(rotated, name)
+		}
+	}
+	if len(rotated) != 1 {
+		t.Fatalf("Rotated files = %v, want one", rotated)
+	}
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Current log file missing after rotation: %v", err)
+	}
+	if info.Size() == 0 || info.Size() > 1<<20 {
+		t.Errorf("Current log file is %d bytes, want the writes after rotation", info.Size())
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the async writer's goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAsyncWriterFlushesOnClose(t *testing.T) {
+	var out lockedBuffer
+	w := NewAsyncWriter(&out, 1000)
+	logger := zerolog.New(w)
+
+	for i := 0; i < 100; i++ {
+		logger.Info().Int("i", i).Msg("queued")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if got := strings.Count(out.String(), "queued"); got != 100 {
+		t.Errorf("%d messages written after Close, want 100", got)
+	}
+}
+
+// slowWriter discards writes after a delay, standing in for a slow disk or
+// a blocked stdout pipe
+type slowWriter struct {
+	delay time.Duration
+}
+
+// Write spins rather than sleeps, since sleeps this short are rounded up
+func (w slowWriter) Write(p []byte) (int, error) {
+	for start := time.Now(); time.Since(start) < w.delay; {
+	}
+	return len(p), nil
+}
+
+// BenchmarkLogging compares the time a caller spends logging a request line
+// to a slow sink, writing synchronously or through the async ring buffer.
+// The async writer drops messages it cannot drain in time, as it would in
+// production.
+func BenchmarkLogging(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		async bool
+	}{
+		{"Sync", false},
+		{"Async", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var out io.Writer = slowWriter{delay: 20 * time.Microsecond}
+			if bc.async {
+				w := NewAsyncWriter(out, 10000)
+				out = w
+
+				// Draining the buffer is not the caller's cost
+				defer func() {
+					b.StopTimer()
+					w.Close()
+				}()
+			}
+			logger := zerolog.New(out).With().Timestamp().Logger()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info().
+					Str("method", "POST").
+					Str("path", "/api/counter/increment").
+					Int("status", 200).
+					Float64("duration_ms", 0.42).
+					Msg("Request processed")
+			}
+		})
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -15,28 +17,40 @@ import (
 
 type VisitCounter struct {
 	Visits atomic.Int64
+
+	// lastSaved is the value most recently written to disk
+	lastSaved atomic.Int64
 }
 
 type counterData struct {
 	Visits int64 `json:"visits"`
 }
 
+// saveInterval is how often the counter is written to disk when it has changed
+const saveInterval = 5 * time.Second
+
+// Defaults for the command-line flags
+const (
+	defaultFilename = "counter.json"
+	defaultPort     = "8090"
+)
+
 var (
-	filename = "counter.json"
-	counter  *VisitCounter
+	counter *VisitCounter
+
+	// saveMu serializes saves so an older value never overwrites a newer one
+	saveMu sync.Mutex
 )
 
-func init() {
-	var err error
-	counter, err = loadCounter()
-	if err != nil {
-		log.Println("Error loading counter:", err)
-		counter = &VisitCounter{} // fallback to zero
-	}
-}
+func saveCounter(counter *VisitCounter, filename string) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
 
-func saveCounter(counter *VisitCounter) error {
 	data := counterData{Visits: counter.Visits.Load()}
+	if data.Visits == counter.lastSaved.Load() {
+		// Nothing changed since the last save
+		return nil
+	}
 
 	jsonBytes, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -55,12 +69,36 @@ func saveCounter(counter *VisitCounter) error {
 	}
 	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 
-	_, err = f.Write(jsonBytes)
-	return err
+	if _, err := f.Write(jsonBytes); err != nil {
+		return err
+	}
+
+	counter.lastSaved.Store(data.Visits)
+	return nil
+}
+
+// startPeriodicSave writes the counter to disk every interval, but only when
+// it has changed, until stop is closed
+func startPeriodicSave(filename string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := saveCounter(counter, filename); err != nil {
+					log.Println("Error saving counter:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
 
 // setupGracefulShutdown configures proper server shutdown
-func setupGracefulShutdown(srv *http.Server) {
+func setupGracefulShutdown(srv *http.Server, filename string, stopSaving chan<- struct{}) {
 	// Channel to listen for interrupt signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -71,8 +109,11 @@ func setupGracefulShutdown(srv *http.Server) {
 
 		log.Println("Server is shutting down...")
 
+		// Stop periodic saves so they don't race the final one
+		close(stopSaving)
+
 		// Save counter state before shutting down
-		if err := saveCounter(counter); err != nil {
+		if err := saveCounter(counter, filename); err != nil {
 			log.Printf("Error saving counter during shutdown: %v", err)
 		}
 
@@ -88,7 +129,7 @@ func setupGracefulShutdown(srv *http.Server) {
 	}()
 }
 
-func loadCounter() (*VisitCounter, error) {
+func loadCounter(filename string) (*VisitCounter, error) {
 	counter := &VisitCounter{}
 
 	f, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0644)
@@ -110,44 +151,66 @@ func loadCounter() (*VisitCounter, error) {
 	}
 
 	counter.Visits.Store(data.Visits)
+	counter.lastSaved.Store(data.Visits)
 	return counter, nil
 }
 
 func updateCounter(w http.ResponseWriter, req *http.Request) {
-	counter.Visits.Add(1)
+	// Saving happens in the background, so the hot path never touches disk
+	writeCounter(w, counter.Visits.Add(1))
+}
 
-	if err := saveCounter(counter); err != nil {
-		log.Println("Error saving counter:", err)
-	}
+// getCounter returns the current value without incrementing it
+func getCounter(w http.ResponseWriter, req *http.Request) {
+	writeCounter(w, counter.Visits.Load())
+}
 
+// writeCounter writes visits as the JSON response body
+func writeCounter(w http.ResponseWriter, visits int64) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(counterData{Visits: counter.Visits.Load()}); err != nil {
+	if err := json.NewEncoder(w).Encode(counterData{Visits: visits}); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
 func main() {
+	file := flag.String("file", defaultFilename, "File the counter is stored in")
+	port := flag.String("port", defaultPort, "Port to serve on")
+	flag.Parse()
+
+	var err error
+	counter, err = loadCounter(*file)
+	if err != nil {
+		log.Println("Error loading counter:", err)
+		counter = &VisitCounter{} // fallback to zero
+	}
 
 	// Create a custom server mux
 	mux := http.NewServeMux()
-	mux.HandleFunc("/api/counter", updateCounter)
+	// Method patterns make the mux answer other methods with 405
+	mux.HandleFunc("POST /api/counter", updateCounter)
+	mux.HandleFunc("GET /api/counter", getCounter)
 
 	// Configure the HTTP server
 	server := &http.Server{
-		Addr:           ":8090",
+		Addr:           ":" + *port,
 		Handler:        mux,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
+	// Save changes in the background
+	stopSaving := make(chan struct{})
+	startPeriodicSave(*file, saveInterval, stopSaving)
+
 	// Set up graceful shutdown
-	setupGracefulShutdown(server)
+	setupGracefulShutdown(server, *file, stopSaving)
 
 	// Start the server
-	log.Printf("Starting Counter Server on port %s...", "8090")
+	log.Printf("Starting Counter Server on port %s...", *port)
 	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed to start: %v", err)
 	}